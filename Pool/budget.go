@@ -0,0 +1,109 @@
+package Pool
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// Weight 设置任务的权重,提交时要从线程池的预算里拿这么多,执行完或者被丢弃后归还,
+// 只在开启了WithBudget的线程池里生效,默认为0即不占预算
+func Weight(n int64) TaskOption {
+	return func(t *task) {
+		t.weight = n
+	}
+}
+
+// budget 带权重的信号量,按申请的先后放行,排在前面的大任务不会被后来的小任务饿死
+type budget struct {
+	mu      sync.Mutex
+	size    int64
+	used    int64
+	waiters list.List //排队等预算的申请,*waiter
+}
+
+type waiter struct {
+	n     int64
+	ready chan struct{} //拿到预算时关闭
+}
+
+func newBudget(size int64) *budget {
+	return &budget{size: size}
+}
+
+// 申请n的预算,前面有人排队或者余额不够时排队等待,ctx取消则放弃
+func (b *budget) acquire(ctx context.Context, n int64) error {
+	b.mu.Lock()
+	if b.waiters.Len() == 0 && b.size-b.used >= n {
+		b.used += n
+		b.mu.Unlock()
+		return nil
+	}
+	w := &waiter{n: n, ready: make(chan struct{})}
+	e := b.waiters.PushBack(w)
+	b.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		select {
+		case <-w.ready:
+			//取消的同时拿到了,还回去
+			b.used -= n
+		default:
+			b.waiters.Remove(e)
+		}
+		//排在队首的走了,后面的可能可以放行了
+		b.notify()
+		return ctx.Err()
+	}
+}
+
+func (b *budget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.notify()
+	b.mu.Unlock()
+}
+
+// 按顺序放行余额够的申请,遇到不够的就停,调用方需持有锁
+func (b *budget) notify() {
+	for e := b.waiters.Front(); e != nil; e = b.waiters.Front() {
+		w := e.Value.(*waiter)
+		if b.size-b.used < w.n {
+			return
+		}
+		b.used += w.n
+		b.waiters.Remove(e)
+		close(w.ready)
+	}
+}
+
+func (b *budget) inUse() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// 提交前按任务权重申请预算,记在任务上
+func (c *core) weigh(t *task) error {
+	if c.budget == nil || t.weight <= 0 {
+		return nil
+	}
+	if err := c.budget.acquire(t.ctx, t.weight); err != nil {
+		return err
+	}
+	t.held = t.weight
+	return nil
+}
+
+// 归还任务占着的预算
+func (c *core) unweigh(t *task) {
+	if t.held > 0 {
+		c.budget.release(t.held)
+		t.held = 0
+	}
+}
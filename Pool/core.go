@@ -0,0 +1,278 @@
+package Pool
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// config New时由Option填充的配置
+type config struct {
+	qcap      int                     //任务队列容量
+	policy    Policy                  //队列满时的策略
+	onPanic   PanicHandler            //任务panic时的回调
+	scale     *Autoscale              //自动伸缩配置,nil表示固定容量
+	expvar    string                  //发布Stats的expvar名字,空表示不发布
+	limit     *limiter                //派发限流器,nil表示不限流
+	steal     bool                    //是否使用工作窃取调度
+	timeout   time.Duration           //任务默认的执行超时,0表示不限时
+	grace     time.Duration           //超时后等任务返回的时间
+	onTimeout TimeoutHandler          //任务超时时的回调,可以为nil
+	tick      time.Duration           //时间轮的刻度
+	prio      bool                    //是否按优先级调度
+	aging     time.Duration           //优先级老化周期,0表示不老化
+	budget    *budget                 //带权重任务的预算,nil表示不限
+	fair      bool                    //是否按租户公平调度
+	tenant    TenantConfig            //没有单独配置的租户用的配置
+	tenants   map[string]TenantConfig //单独配置的租户
+	watch     *watchdog               //记录执行中任务的看门狗,nil表示不记录
+	onStart   func() interface{}      //worker启动时的回调,返回worker的本地值
+	onStop    func(interface{})       //worker退出时的回调
+}
+
+func defaultConfig() config {
+	return config{qcap: DefaultQueueSize, policy: Block, onPanic: printPanic, grace: DefaultGrace, tick: DefaultTick}
+}
+
+// core 两种调度实现共用的部分:配置,统计,panic隔离,限流和关闭信号
+type core struct {
+	config
+	stats  counters           //累计计数,原子操作
+	quit   chan struct{}      //关闭时关闭,通知后台协程退出
+	ctx    context.Context    //执行中任务的上层ctx,ShutdownNow时取消
+	kill   context.CancelFunc //取消ctx
+	keys   keyed              //AssignKeyed的按key排队
+	timers wheel              //AssignAfter和AssignAt的时间轮
+	live   sync.WaitGroup     //还没退出的worker,掉队的不算,Shutdown等它们执行完OnWorkerStop
+	gids   sync.Map           //worker协程的id,用来判断调用方是不是worker
+}
+
+func (c *core) init(cfg config) {
+	c.config = cfg
+	c.quit = make(chan struct{})
+	c.ctx, c.kill = context.WithCancel(context.Background())
+}
+
+func (c *core) reject(err error) Receipt {
+	atomic.AddInt64(&c.stats.rejected, 1)
+	return Receipt{Status: Rejected, Err: err}
+}
+
+// 提交前的检查,不通过时返回拒绝原因
+func (c *core) admit(t *task) error {
+	atomic.AddInt64(&c.stats.submitted, 1)
+	//负数的令牌会反过来往桶里加
+	if t.cost < 0 {
+		return ErrBadCost
+	}
+	if c.limit != nil && t.cost > c.limit.burst {
+		return ErrOverBurst
+	}
+	if c.budget != nil && t.weight > c.budget.size {
+		return ErrOverBudget
+	}
+	return nil
+}
+
+// 关闭后还能不能提交,keyed任务已经接收的后续任务在ShutdownNow之前都放行
+func (c *core) resumable(t *task) bool {
+	return t.follow && c.ctx.Err() == nil
+}
+
+// 在worker上执行一个已出队并拿到令牌的任务,
+// 任务超时掉队时先调用abandon放弃当前worker,等任务返回后再返回errStraggler
+func (c *core) run(w *worker, t *task, abandon func()) error {
+	//ShutdownNow时要能取消执行中的任务,同时保留提交方ctx里的值
+	ctx, cancel := context.WithCancel(t.ctx)
+	stop := context.AfterFunc(c.ctx, cancel)
+	//任务返回后ctx先不取消,等它提交的子任务也都结束
+	s := &scope{n: 1, up: t.scope, end: []func(){func() {
+		stop()
+		cancel()
+	}}}
+	t.scope = nil
+	defer s.release()
+	if w != nil && c.onStart != nil {
+		ctx = context.WithValue(ctx, localKey{}, w.value)
+	}
+	c.stats.wait.observe(time.Since(t.at))
+	var dl *deadline
+	if d := c.timeoutOf(t); d > 0 {
+		var release context.CancelFunc
+		ctx, dl, release = c.deadline(ctx, d, abandon)
+		s.end = append(s.end, release)
+	}
+	t.ctx = context.WithValue(ctx, scopeKey{}, s)
+	c.exec(w, t)
+	if dl != nil && !dl.finish() {
+		return errStraggler
+	}
+	return nil
+}
+
+// scopeKey 执行中任务的ctx里存放它的scope的键
+type scopeKey struct{}
+
+// scope 执行中任务的ctx的生命周期,任务返回并且用这个ctx提交的子任务都执行完或者被丢弃后才取消ctx,
+// 子任务仍然跟着提交方的ctx,ShutdownNow和超时取消,只是不会因为父任务先返回而被丢弃
+type scope struct {
+	n   int32    //任务自己加上还没结束的子任务数,原子操作
+	end []func() //归零时依次调用,取消ctx并注销回调
+	up  *scope   //任务自己占着的上一层scope,归零时释放
+}
+
+// ctx所在的执行中任务的scope,不在任务里时返回nil
+func scopeOf(ctx context.Context) *scope {
+	s, _ := ctx.Value(scopeKey{}).(*scope)
+	return s
+}
+
+// acquire 多一个要等的子任务,s为nil时什么也不做
+func (s *scope) acquire() *scope {
+	if s != nil {
+		atomic.AddInt32(&s.n, 1)
+	}
+	return s
+}
+
+// release 一个子任务或者任务自己结束,s为nil时什么也不做
+func (s *scope) release() {
+	if s == nil || atomic.AddInt32(&s.n, -1) != 0 {
+		return
+	}
+	for _, f := range s.end {
+		f()
+	}
+	s.up.release()
+}
+
+// 执行任务并记录耗时和结果,w为nil表示在调用方的协程上执行
+func (c *core) exec(w *worker, t *task) {
+	if c.watch != nil {
+		done := c.watch.track(StuckTask{Name: "pool", Tenant: t.tenant, Priority: t.priority})
+		defer done()
+	}
+	start := time.Now()
+	failed := c.safe(w, t)
+	c.unweigh(t)
+	c.stats.exec.observe(time.Since(start))
+	atomic.AddInt64(&c.stats.completed, 1)
+	if failed {
+		atomic.AddInt64(&c.stats.failed, 1)
+	}
+}
+
+// 执行任务并隔离panic,返回任务是否失败
+func (c *core) safe(w *worker, t *task) (failed bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			atomic.AddInt64(&c.stats.panicked, 1)
+			c.onPanic(v, debug.Stack())
+			failed = true
+		}
+	}()
+	if w == nil {
+		t.fn(t.ctx)
+	} else {
+		w.Do(t)
+	}
+	return t.err != nil
+}
+
+// worker协程启动时调用,在spawn里已经计入live
+func (c *core) enter(w *worker) {
+	w.gid = goid()
+	c.gids.Store(w.gid, struct{}{})
+	if c.onStart == nil {
+		return
+	}
+	defer c.recover()
+	w.value = c.onStart()
+}
+
+// worker协程退出时调用,counted为false表示掉队时已经从live里减掉了
+func (c *core) leave(w *worker, counted bool) {
+	c.gids.Delete(w.gid)
+	if counted {
+		defer c.live.Done()
+	}
+	if c.onStop == nil {
+		return
+	}
+	defer c.recover()
+	c.onStop(w.value)
+}
+
+// 掉队的worker被放弃后不再算作worker,在它上面Wait的组不会再帮忙执行任务
+func (c *core) disown(w *worker) {
+	c.gids.Delete(w.gid)
+}
+
+// 调用方是不是这个线程池的worker协程
+func (c *core) onWorker() bool {
+	_, ok := c.gids.Load(goid())
+	return ok
+}
+
+// 回调panic时交给PanicHandler,不影响worker
+func (c *core) recover() {
+	if v := recover(); v != nil {
+		c.onPanic(v, debug.Stack())
+	}
+}
+
+// 等所有worker退出并执行完OnWorkerStop,ctx到期时返回ctx的错误
+func (c *core) drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		c.live.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 在调用方协程上执行任务,CallerRuns策略和组的Wait使用,执行超时照样生效,
+// 只是调用方协程没法放弃,掉队时只记账和回调,还是要等任务返回
+func (c *core) callerRun(t *task) Receipt {
+	if err := c.throttle(t.ctx, t); err != nil {
+		return c.reject(err)
+	}
+	if d := c.timeoutOf(t); d > 0 {
+		ctx, dl, release := c.deadline(t.ctx, d, func() {})
+		defer release()
+		defer dl.finish()
+		t.ctx = ctx
+	}
+	c.exec(nil, t)
+	return Receipt{Status: CallerRan}
+}
+
+// 丢弃任务时的通用收尾,不含各实现自己的pending计数
+func (c *core) drop(t *task, err error) {
+	if t.stop != nil {
+		t.stop()
+	}
+	c.dropped(t, err)
+}
+
+// 同drop,但不注销ctx的回调,给回调自己用
+func (c *core) dropped(t *task, err error) {
+	c.unweigh(t)
+	t.scope.release()
+	t.scope = nil
+	if t.drop != nil {
+		t.drop(err)
+	}
+	atomic.AddInt64(&c.stats.dropped, 1)
+}
+
+func (c *core) Panics() int64 {
+	return atomic.LoadInt64(&c.stats.panicked)
+}
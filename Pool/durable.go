@@ -0,0 +1,389 @@
+package Pool
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrUnknownTask   = errors.New("durable task name not registered")
+	ErrDurableClosed = errors.New("durable queue is closed")
+)
+
+// Handler 落盘任务的处理函数,payload为入队时的数据,返回nil才算完成
+type Handler func(ctx context.Context, payload []byte) error
+
+// Durable 落盘的任务队列,任务先追加到日志再交给线程池,处理成功后追加一条确认,
+// 处理失败时按重试策略重试,重试用完仍失败的留在日志里并交给OnDurableFailure,
+// 重启后没有确认的任务会重放,所以同一个任务可能执行不止一次,处理函数要能承受重复执行
+type Durable interface {
+	Enqueue(name string, payload []byte) error //追加到日志并提交给线程池,日志落盘后才返回
+	Pending() int                              //还没确认的任务数
+	Compact() error                            //重写日志,只保留没确认的任务
+	Close() error
+}
+
+// DurableOption OpenDurable的配置项
+type DurableOption func(d *durable)
+
+// WithDurableRetry 设置处理失败时的重试策略,默认为RetryPolicy{},即最多执行DefaultMaxAttempts次
+func WithDurableRetry(policy RetryPolicy) DurableOption {
+	return func(d *durable) {
+		d.retry = policy
+	}
+}
+
+// OnDurableFailure 设置重试用完仍失败时的回调,err为最后一次的错误,任务没有确认,下次打开时会重放
+func OnDurableFailure(f func(name string, payload []byte, err error)) DurableOption {
+	return func(d *durable) {
+		d.onFail = f
+	}
+}
+
+// 日志记录的类型
+const (
+	recEnqueue byte = 1
+	recAck     byte = 2
+)
+
+// 日志文件名
+const durableLog = "queue.log"
+
+// 已确认的记录超过这个数并且多于没确认的时,确认时顺便压缩日志
+const compactAfter = 1024
+
+// 单条记录的上限,超过的当作损坏
+const maxRecord = 1 << 30
+
+var errCorrupt = errors.New("durable log record corrupted")
+
+// 一条没确认的任务
+type record struct {
+	name    string
+	payload []byte
+}
+
+// 落盘队列实体
+type durable struct {
+	p        Pool
+	dir      string
+	handlers map[string]Handler
+	retry    RetryPolicy
+	onFail   func(name string, payload []byte, err error)
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64              //日志里完整记录的长度
+	next    uint64             //下一个任务id
+	pending map[uint64]*record //还没确认的任务
+	dead    int                //日志里已经确认的任务数,压缩后清零
+	closed  bool
+}
+
+// OpenDurable 打开dir下的任务日志,不存在就新建,handlers按任务名注册处理函数,
+// 打开时会先压缩日志,再把上次没确认的任务按入队顺序重新提交给p
+func OpenDurable(dir string, p Pool, handlers map[string]Handler, opts ...DurableOption) (Durable, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	d := &durable{p: p, dir: dir, handlers: handlers, pending: make(map[uint64]*record)}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	err := d.compact()
+	d.mu.Unlock()
+	if err != nil {
+		d.f.Close()
+		return nil, err
+	}
+
+	//先取出来再提交,提交后任务随时可能确认
+	ids := d.sorted()
+	rs := make([]*record, len(ids))
+	for i, id := range ids {
+		rs[i] = d.pending[id]
+	}
+	for i, id := range ids {
+		r := rs[i]
+		if _, ok := handlers[r.name]; !ok {
+			//没注册的留在日志里,等注册了它的版本来处理
+			fmt.Printf("durable task %q not registered , left in log\n", r.name)
+			continue
+		}
+		d.submit(id, r)
+	}
+	return d, nil
+}
+
+// 读取日志,重建没确认的任务,尾部写了一半的记录截掉
+func (d *durable) load() error {
+	f, err := os.OpenFile(filepath.Join(d.dir, durableLog), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	var good int64
+	for {
+		op, id, name, payload, n, err := readRecord(r)
+		if err != nil {
+			break
+		}
+		good += n
+		if id >= d.next {
+			d.next = id + 1
+		}
+		switch op {
+		case recEnqueue:
+			d.pending[id] = &record{name: name, payload: payload}
+		case recAck:
+			if _, ok := d.pending[id]; ok {
+				delete(d.pending, id)
+				d.dead++
+			}
+		}
+	}
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	d.f = f
+	d.size = good
+	return nil
+}
+
+func (d *durable) Enqueue(name string, payload []byte) error {
+	if _, ok := d.handlers[name]; !ok {
+		return ErrUnknownTask
+	}
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return ErrDurableClosed
+	}
+	id := d.next
+	d.next++
+	if err := d.write(encodeRecord(recEnqueue, id, name, payload), true); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	r := &record{name: name, payload: append([]byte(nil), payload...)}
+	d.pending[id] = r
+	d.mu.Unlock()
+
+	if rc := d.submit(id, r); rc.Status == Rejected {
+		//线程池不接收就当没入过队
+		d.ack(id)
+		return rc.Err
+	}
+	return nil
+}
+
+// 按重试策略提交给线程池,处理成功后确认,重试用完仍失败的交给onFail
+func (d *durable) submit(id uint64, r *record) Receipt {
+	h := d.handlers[r.name]
+	fu, rc := submitRetry(context.Background(), d.p, d.retry, func(ctx context.Context) (struct{}, error) {
+		return struct{}{}, h(ctx, r.payload)
+	})
+	if rc.Status == Rejected {
+		return rc
+	}
+	fu.onDone(func() {
+		if _, err := fu.Get(); err == nil {
+			d.ack(id)
+		} else if d.onFail != nil {
+			d.onFail(r.name, r.payload, err)
+		}
+	})
+	return rc
+}
+
+// 追加确认记录,确认不刷盘,丢了最多是重放一次
+func (d *durable) ack(id uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.pending[id]; !ok || d.closed {
+		return
+	}
+	if err := d.write(encodeRecord(recAck, id, "", nil), false); err != nil {
+		return
+	}
+	delete(d.pending, id)
+	d.dead++
+	if d.dead > compactAfter && d.dead > len(d.pending) {
+		if err := d.compact(); err != nil {
+			fmt.Printf("durable queue compact failed : %v\n", err)
+		}
+	}
+}
+
+// 追加一条记录,sync为true时等落盘,写失败时截掉写了一半的部分,免得后面的记录读不出来,调用方需持有锁
+func (d *durable) write(b []byte, sync bool) error {
+	if _, err := d.f.Write(b); err != nil {
+		if d.f.Truncate(d.size) == nil {
+			d.f.Seek(d.size, io.SeekStart)
+		}
+		return err
+	}
+	d.size += int64(len(b))
+	if sync {
+		return d.f.Sync()
+	}
+	return nil
+}
+
+func (d *durable) Pending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.pending)
+}
+
+func (d *durable) Compact() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return ErrDurableClosed
+	}
+	return d.compact()
+}
+
+// 把没确认的任务写到新文件再换掉旧日志,调用方需持有锁
+func (d *durable) compact() error {
+	tmp := filepath.Join(d.dir, durableLog+".tmp")
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	var size int64
+	for _, id := range d.sorted() {
+		r := d.pending[id]
+		var n int
+		if n, err = w.Write(encodeRecord(recEnqueue, id, r.name, r.payload)); err != nil {
+			break
+		}
+		size += int64(n)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(d.dir, durableLog))
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	//改名后旧日志已经没了,目录刷盘失败也要换成新文件,否则后面的记录都写进了被删掉的旧文件
+	d.f.Close()
+	d.f = f
+	d.size = size
+	d.dead = 0
+	return syncDir(d.dir)
+}
+
+// 按入队顺序排好的没确认的任务id
+func (d *durable) sorted() []uint64 {
+	ids := make([]uint64, 0, len(d.pending))
+	for id := range d.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// 目录刷盘,保证改名落盘
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+func (d *durable) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+	return d.f.Close()
+}
+
+// 编码一条记录:4字节body长度,4字节body的crc32,body为类型,id,以及入队记录的任务名和payload
+func encodeRecord(op byte, id uint64, name string, payload []byte) []byte {
+	body := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(name)+len(payload))
+	body = append(body, op)
+	body = binary.AppendUvarint(body, id)
+	if op == recEnqueue {
+		body = binary.AppendUvarint(body, uint64(len(name)))
+		body = append(body, name...)
+		body = append(body, payload...)
+	}
+	b := make([]byte, 8, 8+len(body))
+	binary.LittleEndian.PutUint32(b[0:], uint32(len(body)))
+	binary.LittleEndian.PutUint32(b[4:], crc32.ChecksumIEEE(body))
+	return append(b, body...)
+}
+
+// 读一条记录,返回记录占的字节数,记录不完整或者校验不对时返回错误
+func readRecord(r *bufio.Reader) (op byte, id uint64, name string, payload []byte, n int64, err error) {
+	var head [8]byte
+	if _, err = io.ReadFull(r, head[:]); err != nil {
+		return
+	}
+	size := binary.LittleEndian.Uint32(head[0:])
+	if size == 0 || size > maxRecord {
+		err = errCorrupt
+		return
+	}
+	body := make([]byte, size)
+	if _, err = io.ReadFull(r, body); err != nil {
+		return
+	}
+	if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(head[4:]) {
+		err = errCorrupt
+		return
+	}
+	op = body[0]
+	rest := body[1:]
+	var k int
+	if id, k = binary.Uvarint(rest); k <= 0 {
+		err = errCorrupt
+		return
+	}
+	rest = rest[k:]
+	if op == recEnqueue {
+		l, k := binary.Uvarint(rest)
+		if k <= 0 || uint64(len(rest)-k) < l {
+			err = errCorrupt
+			return
+		}
+		name = string(rest[k : k+int(l)])
+		payload = rest[k+int(l):]
+	}
+	n = int64(8 + size)
+	return
+}
@@ -0,0 +1,137 @@
+package Pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 第一轮一半任务重试用完仍失败,另一半执行到一半卡住,这时不Close直接丢下,日志尾部再写半条记录模拟写到一半崩溃,
+// 重新打开后没确认的任务按入队顺序重放,半条记录被截掉,确认后压缩把日志清空
+func TestDurableCrashRecovery(t *testing.T) {
+	dir := t.TempDir()
+	const n = 10
+
+	var failed, started int64
+	hang := make(chan struct{})
+	p1 := New(n)
+	d1, err := OpenDurable(dir, p1, map[string]Handler{
+		"job": func(ctx context.Context, payload []byte) error {
+			if i, _ := strconv.Atoi(string(payload)); i%2 == 0 {
+				return errors.New("failed")
+			}
+			atomic.AddInt64(&started, 1)
+			select {
+			case <-hang:
+			case <-ctx.Done():
+			}
+			return errors.New("crashed")
+		},
+	}, WithDurableRetry(RetryPolicy{MaxAttempts: 2, Initial: 1}), OnDurableFailure(func(name string, payload []byte, err error) {
+		atomic.AddInt64(&failed, 1)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//崩溃的进程不会Close,测试结束后再释放它的文件和线程池
+	t.Cleanup(func() {
+		close(hang)
+		d1.Close()
+	})
+	for i := 0; i < n; i++ {
+		if err := d1.Enqueue("job", []byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d1.Enqueue("unknown", nil); err != ErrUnknownTask {
+		t.Fatalf("unknown task: %v", err)
+	}
+	for atomic.LoadInt64(&failed) < n/2 || atomic.LoadInt64(&started) < n/2 {
+		time.Sleep(time.Millisecond)
+	}
+	if d1.Pending() != n {
+		t.Fatalf("pending %d , want %d", d1.Pending(), n)
+	}
+
+	//崩溃:卡住的任务没有返回,线程池直接停掉,失败的任务也不会再重试
+	p1.ShutdownNow()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p1.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if d1.Pending() != n {
+		t.Fatalf("pending %d after crash , want %d", d1.Pending(), n)
+	}
+
+	log := filepath.Join(dir, durableLog)
+	f, err := os.OpenFile(log, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := encodeRecord(recEnqueue, 1000, "job", []byte("torn"))
+	f.Write(torn[:len(torn)/2])
+	f.Close()
+
+	var mu sync.Mutex
+	var order []string
+	p2 := New(1)
+	d2, err := OpenDurable(dir, p2, map[string]Handler{
+		"job": func(ctx context.Context, payload []byte) error {
+			mu.Lock()
+			order = append(order, string(payload))
+			mu.Unlock()
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p2.Wait()
+	if len(order) != n {
+		t.Fatalf("replayed %v", order)
+	}
+	for i, v := range order {
+		if v != fmt.Sprint(i) {
+			t.Fatalf("replayed out of order %v", order)
+		}
+	}
+	if d2.Pending() != 0 {
+		t.Fatalf("pending %d after replay", d2.Pending())
+	}
+
+	if err := d2.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := os.Stat(log); err != nil || st.Size() != 0 {
+		t.Fatalf("log not compacted: %v %v", st, err)
+	}
+	if err := d2.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p2.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	p3 := New(1)
+	d3, err := OpenDurable(dir, p3, map[string]Handler{"job": func(context.Context, []byte) error { return nil }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d3.Pending() != 0 {
+		t.Fatalf("pending %d after reopen", d3.Pending())
+	}
+	if err := d3.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p3.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,201 @@
+package Pool
+
+// Tenant 设置任务所属的租户,只在开启了WithTenants的线程池里生效,没有设置的任务属于名字为空的租户
+func Tenant(name string) TaskOption {
+	return func(t *task) {
+		t.tenant = name
+	}
+}
+
+// TenantConfig 单个租户的调度配置
+type TenantConfig struct {
+	Weight      int //每轮能派发的任务数,不大于0时为1
+	MaxInFlight int //同时执行的任务上限,不大于0表示不限
+}
+
+// TenantStats 单个租户的运行状态
+type TenantStats struct {
+	Queued     int   //排队的任务数
+	Running    int   //正在执行的任务数
+	Dispatched int64 //租户这次活跃以来派发给worker的任务数
+}
+
+// tenant 一个租户的队列和派发状态
+type tenant struct {
+	name       string
+	cfg        TenantConfig
+	q          fifo
+	deficit    int //本轮还能派发的任务数
+	running    int //正在执行的任务数
+	dispatched int64
+	ringed     bool //是否在轮转里
+}
+
+func (tn *tenant) capped() bool {
+	return tn.cfg.MaxInFlight > 0 && tn.running >= tn.cfg.MaxInFlight
+}
+
+// fairq 按租户做差额轮转(DRR)的队列,每轮每个租户最多派发Weight个任务,
+// 达到MaxInFlight的租户本轮跳过,任务留在队列里等它有任务执行完,
+// 没有任务排队也没有任务在执行的租户随即删掉,租户名可以是不断变化的用户id
+type fairq struct {
+	def     TenantConfig
+	conf    map[string]TenantConfig
+	tenants map[string]*tenant //活跃的租户
+	ring    []*tenant          //有任务排队的租户,按轮转顺序
+	cur     int                //轮转到的位置
+	size    int
+}
+
+func newFairq(def TenantConfig, conf map[string]TenantConfig) *fairq {
+	return &fairq{def: def, conf: conf, tenants: make(map[string]*tenant)}
+}
+
+func (q *fairq) tenantOf(name string) *tenant {
+	tn := q.tenants[name]
+	if tn == nil {
+		cfg, ok := q.conf[name]
+		if !ok {
+			cfg = q.def
+		}
+		if cfg.Weight <= 0 {
+			cfg.Weight = 1
+		}
+		tn = &tenant{name: name, cfg: cfg}
+		q.tenants[name] = tn
+	}
+	return tn
+}
+
+func (q *fairq) push(t *task) {
+	tn := q.tenantOf(t.tenant)
+	tn.q.push(t)
+	q.size++
+	if !tn.ringed {
+		tn.ringed = true
+		q.ring = append(q.ring, tn)
+	}
+}
+
+// take 按轮转取下一个可以派发的任务,所有有任务的租户都到了上限时返回nil
+func (q *fairq) take() *task {
+	for skipped := 0; skipped < len(q.ring); {
+		tn := q.ring[q.cur]
+		if tn.capped() {
+			q.cur = (q.cur + 1) % len(q.ring)
+			skipped++
+			continue
+		}
+		//轮到它时补上这一轮的额度
+		if tn.deficit <= 0 {
+			tn.deficit += tn.cfg.Weight
+		}
+		t := tn.q.pop()
+		q.size--
+		tn.deficit--
+		tn.running++
+		tn.dispatched++
+		if tn.q.len() == 0 {
+			q.unring(q.cur)
+		} else if tn.deficit <= 0 {
+			q.cur = (q.cur + 1) % len(q.ring)
+		}
+		return t
+	}
+	return nil
+}
+
+// 租户没有任务排队也没有任务在执行时删掉
+func (q *fairq) forget(tn *tenant) {
+	if !tn.ringed && tn.running == 0 {
+		delete(q.tenants, tn.name)
+	}
+}
+
+// 把第i个租户移出轮转,轮转位置跟着调整,没有任务在执行的顺便删掉
+func (q *fairq) unring(i int) {
+	tn := q.ring[i]
+	tn.ringed = false
+	tn.deficit = 0
+	q.forget(tn)
+	copy(q.ring[i:], q.ring[i+1:])
+	q.ring[len(q.ring)-1] = nil
+	q.ring = q.ring[:len(q.ring)-1]
+	if i < q.cur {
+		q.cur--
+	}
+	if q.cur >= len(q.ring) {
+		q.cur = 0
+	}
+}
+
+func (q *fairq) done(t *task) {
+	tn := q.tenants[t.tenant]
+	tn.running--
+	q.forget(tn)
+}
+
+// pop 不管上限直接出队,ShutdownNow时用,顺序无所谓
+func (q *fairq) pop() *task {
+	return q.evict()
+}
+
+// evict DropOldest时丢弃排队最多的租户最老的任务,免得挤掉别的租户
+func (q *fairq) evict() *task {
+	if q.size == 0 {
+		return nil
+	}
+	at := 0
+	for i, tn := range q.ring {
+		if tn.q.len() > q.ring[at].q.len() {
+			at = i
+		}
+	}
+	tn := q.ring[at]
+	t := tn.q.pop()
+	q.size--
+	if tn.q.len() == 0 {
+		q.unring(at)
+	}
+	return t
+}
+
+// peek 同take,跳过到了上限的租户,但不出队也不动轮转位置
+func (q *fairq) peek() *task {
+	for i := range q.ring {
+		if tn := q.ring[(q.cur+i)%len(q.ring)]; !tn.capped() {
+			return tn.q.peek()
+		}
+	}
+	return nil
+}
+
+func (q *fairq) remove(t *task) bool {
+	tn := q.tenants[t.tenant]
+	if tn == nil || !tn.q.remove(t) {
+		return false
+	}
+	q.size--
+	if tn.q.len() == 0 {
+		for i, v := range q.ring {
+			if v == tn {
+				q.unring(i)
+				break
+			}
+		}
+	}
+	return true
+}
+
+func (q *fairq) len() int {
+	return q.size
+}
+
+// 每个租户的运行状态
+func (q *fairq) stats() map[string]TenantStats {
+	m := make(map[string]TenantStats, len(q.tenants))
+	for name, tn := range q.tenants {
+		m[name] = TenantStats{Queued: tn.q.len(), Running: tn.running, Dispatched: tn.dispatched}
+	}
+	return m
+}
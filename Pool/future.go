@@ -0,0 +1,228 @@
+package Pool
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+	"sync"
+)
+
+// ErrNoFuture 组合子没有传入任何Future
+var ErrNoFuture = errors.New("no future to wait")
+
+// Future 异步任务的结果句柄
+type Future[T any] interface {
+	Get() (T, error)                       //阻塞直到任务完成
+	GetCtx(ctx context.Context) (T, error) //同Get,ctx取消时提前返回
+	Done() <-chan struct{}                 //任务完成时关闭
+	Cancel() bool                          //取消任务,任务已经完成则返回false
+	onDone(f func())                       //注册完成回调,组合子靠它串联
+	back() *future[T]
+}
+
+// 结果句柄实体
+type future[T any] struct {
+	mu     sync.Mutex
+	done   chan struct{}
+	val    T
+	err    error
+	ok     bool     //是否已经有结果
+	cbs    []func() //完成回调,在完成的那个协程上依次执行
+	cancel func()   //取消上游,有结果后也会调用一次
+}
+
+func newFuture[T any](cancel func()) *future[T] {
+	return &future[T]{done: make(chan struct{}), cancel: cancel}
+}
+
+// Submit 把有返回值的函数提交到线程池,返回结果句柄
+func Submit[T any](p Pool, f func() (T, error)) Future[T] {
+	return SubmitCtx(context.Background(), p, func(context.Context) (T, error) { return f() })
+}
+
+// SubmitCtx 同Submit,ctx会透传给任务,Cancel也会取消这个ctx
+func SubmitCtx[T any](ctx context.Context, p Pool, f func(ctx context.Context) (T, error)) Future[T] {
+	ctx, cancel := context.WithCancel(ctx)
+	fu := newFuture[T](cancel)
+	var zero T
+	t := newTask(ctx, nil)
+	t.fn = func(ctx context.Context) {
+		defer catch(func(err error) { fu.resolve(zero, err) })
+		v, err := f(ctx)
+		t.err = err
+		fu.resolve(v, err)
+	}
+	//任务没执行就被丢弃时也要给出结果,免得Get永远阻塞
+	t.drop = func(err error) { fu.resolve(zero, err) }
+	if r := sched(p).submit(t); r.Status == Rejected {
+		fu.resolve(zero, r.Err)
+	}
+	return fu
+}
+
+// 写入结果,只有第一次生效,随后释放上游(ctx或者组合子的其余成员)
+func (f *future[T]) resolve(v T, err error) bool {
+	f.mu.Lock()
+	if f.ok {
+		f.mu.Unlock()
+		return false
+	}
+	f.val, f.err, f.ok = v, err, true
+	cbs := f.cbs
+	f.cbs = nil
+	close(f.done)
+	f.mu.Unlock()
+
+	if f.cancel != nil {
+		f.cancel()
+	}
+	for _, cb := range cbs {
+		cb()
+	}
+	return true
+}
+
+func (f *future[T]) Get() (T, error) {
+	<-f.done
+	return f.val, f.err
+}
+
+func (f *future[T]) GetCtx(ctx context.Context) (T, error) {
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+func (f *future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *future[T]) Cancel() bool {
+	var zero T
+	return f.resolve(zero, context.Canceled)
+}
+
+func (f *future[T]) onDone(cb func()) {
+	f.mu.Lock()
+	if !f.ok {
+		f.cbs = append(f.cbs, cb)
+		f.mu.Unlock()
+		return
+	}
+	f.mu.Unlock()
+	cb()
+}
+
+func (f *future[T]) back() *future[T] {
+	return f
+}
+
+// Then 上游成功后在完成它的协程上执行fn,上游失败则直接透传错误,
+// fn panic时结果为*PanicError,panic不会再抛出去,免得搞垮完成上游的那个协程
+func Then[T, R any](f Future[T], fn func(T) (R, error)) Future[R] {
+	next := newFuture[R](func() { f.Cancel() })
+	f.onDone(func() {
+		var zero R
+		v, err := f.back().val, f.back().err
+		if err != nil {
+			next.resolve(zero, err)
+			return
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				next.resolve(zero, &PanicError{Value: r, Stack: debug.Stack()})
+			}
+		}()
+		next.resolve(fn(v))
+	})
+	return next
+}
+
+// All 全部成功时按顺序返回所有结果,任意一个失败就返回该错误并取消其余的
+func All[T any](fs ...Future[T]) Future[[]T] {
+	all := newFuture[[]T](func() { cancelAll(fs) })
+	if len(fs) == 0 {
+		all.resolve([]T{}, nil)
+		return all
+	}
+	vals := make([]T, len(fs))
+	var mu sync.Mutex
+	left := len(fs)
+	for i, f := range fs {
+		i, f := i, f
+		f.onDone(func() {
+			if err := f.back().err; err != nil {
+				all.resolve(nil, err)
+				return
+			}
+			mu.Lock()
+			vals[i] = f.back().val
+			left--
+			finished := left == 0
+			mu.Unlock()
+			if finished {
+				all.resolve(vals, nil)
+			}
+		})
+	}
+	return all
+}
+
+// Any 返回第一个成功的结果并取消其余的,全部失败则返回合并后的错误
+func Any[T any](fs ...Future[T]) Future[T] {
+	first := newFuture[T](func() { cancelAll(fs) })
+	if len(fs) == 0 {
+		var zero T
+		first.resolve(zero, ErrNoFuture)
+		return first
+	}
+	errs := make([]error, len(fs))
+	var mu sync.Mutex
+	left := len(fs)
+	for i, f := range fs {
+		i, f := i, f
+		f.onDone(func() {
+			if err := f.back().err; err != nil {
+				mu.Lock()
+				errs[i] = err
+				left--
+				finished := left == 0
+				mu.Unlock()
+				if finished {
+					var zero T
+					first.resolve(zero, errors.Join(errs...))
+				}
+				return
+			}
+			first.resolve(f.back().val, nil)
+		})
+	}
+	return first
+}
+
+// Race 返回第一个完成的结果,不管成功失败,并取消其余的
+func Race[T any](fs ...Future[T]) Future[T] {
+	first := newFuture[T](func() { cancelAll(fs) })
+	if len(fs) == 0 {
+		var zero T
+		first.resolve(zero, ErrNoFuture)
+		return first
+	}
+	for _, f := range fs {
+		f := f
+		f.onDone(func() {
+			first.resolve(f.back().val, f.back().err)
+		})
+	}
+	return first
+}
+
+func cancelAll[T any](fs []Future[T]) {
+	for _, f := range fs {
+		f.Cancel()
+	}
+}
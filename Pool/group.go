@@ -0,0 +1,167 @@
+package Pool
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Group 一组在线程池里执行的任务,有自己的Wait,不会等别人提交的任务
+type Group interface {
+	Go(f func() error)                       //提交任务到线程池
+	GoCtx(f func(ctx context.Context) error) //同Go,任务拿到组的ctx
+	Wait() error                             //等待组内(包括子组)的任务执行完,返回组内所有任务的错误
+	Group(opts ...GroupOption) Group         //创建子组,子组的ctx派生自本组,本组的Wait也会等子组的任务
+	Context() context.Context                //组的ctx,组被取消或者Wait返回后会被取消
+}
+
+// GroupOption 任务组的可选配置
+type GroupOption func(g *group)
+
+// CancelOnError 组内第一个任务失败时取消组的ctx,还没开始的任务不再执行
+func CancelOnError() GroupOption {
+	return func(g *group) {
+		g.failFast = true
+	}
+}
+
+// 任务组实体
+type group struct {
+	p        scheduler
+	ctx      context.Context
+	cancel   context.CancelFunc
+	parent   *group
+	failFast bool
+
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	errs   []error
+	queued map[*member]struct{} //已经提交但还没开始执行的任务
+}
+
+// 组内的一个任务,由worker或者Wait的调用方认领执行
+type member struct {
+	fn func(ctx context.Context) error
+}
+
+func newGroup(p scheduler, ctx context.Context, parent *group, opts []GroupOption) *group {
+	g := &group{p: p, parent: parent, queued: make(map[*member]struct{})}
+	g.ctx, g.cancel = context.WithCancel(ctx)
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// Group 创建一个任务组,组内任务的错误和等待与线程池的其他任务互不影响,仍然受线程池的并发限制
+func (p *pool) Group(ctx context.Context, opts ...GroupOption) Group {
+	return newGroup(p, ctx, nil, opts)
+}
+
+func (p *stealPool) Group(ctx context.Context, opts ...GroupOption) Group {
+	return newGroup(p, ctx, nil, opts)
+}
+
+func (g *group) Go(f func() error) {
+	g.GoCtx(func(context.Context) error { return f() })
+}
+
+func (g *group) GoCtx(f func(ctx context.Context) error) {
+	for x := g; x != nil; x = x.parent {
+		x.wg.Add(1)
+	}
+	m := &member{fn: f}
+	g.mu.Lock()
+	g.queued[m] = struct{}{}
+	g.mu.Unlock()
+
+	t := newTask(g.ctx, func(ctx context.Context) {
+		if g.claim(m) {
+			g.exec(ctx, m)
+		}
+	})
+	t.drop = func(err error) {
+		if g.claim(m) {
+			g.finish(err, false)
+		}
+	}
+	if r := g.p.submit(t); r.Status == Rejected && g.claim(m) {
+		g.finish(r.Err, false)
+	}
+}
+
+// 认领一个还没开始的任务,已经被别人认领返回false
+func (g *group) claim(m *member) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.queued[m]; !ok {
+		return false
+	}
+	delete(g.queued, m)
+	return true
+}
+
+// 执行任务,panic时也记下错误
+func (g *group) exec(ctx context.Context, m *member) {
+	defer catch(func(err error) { g.finish(err, true) })
+	g.finish(m.fn(ctx), true)
+}
+
+// 任务结束,ran表示任务是否执行过,错误只记在本组,计数从本组一直减到最上层,
+// CancelOnError的组失败后,被连带取消的任务(没执行的,或者返回了取消错误的)不再记错误
+func (g *group) finish(err error, ran bool) {
+	if err != nil {
+		g.mu.Lock()
+		if !g.failFast || len(g.errs) == 0 || ran && !errors.Is(err, context.Canceled) {
+			g.errs = append(g.errs, err)
+		}
+		g.mu.Unlock()
+		if g.failFast {
+			g.cancel()
+		}
+	}
+	for x := g; x != nil; x = x.parent {
+		x.wg.Done()
+	}
+}
+
+// 取出一个还没开始的任务
+func (g *group) steal() *member {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for m := range g.queued {
+		delete(g.queued, m)
+		return m
+	}
+	return nil
+}
+
+// Wait 在线程池的worker上Wait时把还没开始的任务拿过来自己执行,免得占满worker死锁,
+// 不在worker上时只等待,组内任务不会跑在调用方协程上超出线程池的并发上限
+func (g *group) Wait() error {
+	if g.p.onWorker() {
+		for m := g.steal(); m != nil; m = g.steal() {
+			if err := g.ctx.Err(); err != nil {
+				g.finish(err, false)
+				continue
+			}
+			t := newTask(g.ctx, func(ctx context.Context) { g.exec(ctx, m) })
+			if r := g.p.callerRun(t); r.Status == Rejected {
+				g.finish(r.Err, false)
+			}
+		}
+	}
+	g.wg.Wait()
+	g.cancel()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return errors.Join(g.errs...)
+}
+
+func (g *group) Group(opts ...GroupOption) Group {
+	return newGroup(g.p, g.ctx, g, opts)
+}
+
+func (g *group) Context() context.Context {
+	return g.ctx
+}
@@ -0,0 +1,77 @@
+package Pool
+
+import (
+	"context"
+	"sync"
+)
+
+// AssignKeyed 同一个key的任务严格按提交顺序逐个执行,不会并发,不同key的任务照常分散到所有worker,
+// 排在key后面的任务直接返回Queued回执,不占队列容量,key的任务都执行完后它的排队会被清掉
+func (p *pool) AssignKeyed(key string, fs ...TaskFunc) []Receipt {
+	return p.keys.assign(p.submit, key, wrap(fs))
+}
+
+// keyed 按key串行执行,同一个key同时最多只有一个任务交给线程池,其余的在这里排队
+type keyed struct {
+	mu sync.Mutex
+	m  map[string][]*task //key在表里说明它有任务在线程池里,值是还没交出去的后续任务
+}
+
+// 提交一组同key的任务,key空闲时直接提交第一个,否则排到这个key的后面
+func (k *keyed) assign(submit func(t *task) Receipt, key string, fs []CtxTaskFunc) []Receipt {
+	rs := make([]Receipt, len(fs))
+	for i, f := range fs {
+		t := newTask(context.Background(), k.chain(submit, key, f))
+		t.drop = func(error) { k.advance(submit, key) }
+
+		k.mu.Lock()
+		if k.m == nil {
+			k.m = make(map[string][]*task)
+		}
+		if q, ok := k.m[key]; ok {
+			k.m[key] = append(q, t)
+			k.mu.Unlock()
+			rs[i] = Receipt{Status: Queued}
+			continue
+		}
+		k.m[key] = nil
+		k.mu.Unlock()
+
+		rs[i] = submit(t)
+		if rs[i].Status == Rejected {
+			k.advance(submit, key)
+		}
+	}
+	return rs
+}
+
+// 包装任务,执行完(包括panic)后把同key的下一个任务交给线程池
+func (k *keyed) chain(submit func(t *task) Receipt, key string, f CtxTaskFunc) CtxTaskFunc {
+	return func(ctx context.Context) {
+		defer k.advance(submit, key)
+		f(ctx)
+	}
+}
+
+// 提交key的下一个任务,没有了就把key从表里删掉
+func (k *keyed) advance(submit func(t *task) Receipt, key string) {
+	for {
+		k.mu.Lock()
+		q := k.m[key]
+		if len(q) == 0 {
+			delete(k.m, key)
+			k.mu.Unlock()
+			return
+		}
+		t := q[0]
+		q[0] = nil //断开引用,方便gc
+		k.m[key] = q[1:]
+		k.mu.Unlock()
+
+		//已经给过调用方Queued回执,不再受队列容量限制,被拒绝(ShutdownNow)就接着下一个
+		t.follow = true
+		if submit(t).Status != Rejected {
+			return
+		}
+	}
+}
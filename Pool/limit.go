@@ -0,0 +1,110 @@
+package Pool
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// limiter 令牌桶限流器,按rate匀速补充令牌,最多攒burst个
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64 //每秒补充的令牌数
+	burst  int     //桶容量
+	tokens float64 //当前令牌数,为负表示已经被预定出去的
+	last   time.Time
+}
+
+func newLimiter(rate float64, burst int) *limiter {
+	return &limiter{rate: rate, burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// 按流逝的时间补充令牌,调用方需持有锁
+func (l *limiter) fill() {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+}
+
+// take 桶里够n个令牌时拿走并返回0,不够时一个也不拿,返回攒够还要等的时间
+func (l *limiter) take(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fill()
+	if l.tokens >= float64(n) {
+		l.tokens -= float64(n)
+		return 0
+	}
+	return time.Duration(math.Ceil((float64(n) - l.tokens) / l.rate * float64(time.Second)))
+}
+
+// reserve 预定n个令牌,返回拿到令牌前还需要等待的时间
+func (l *limiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.fill()
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// 归还没用上的预定
+func (l *limiter) unreserve(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens += float64(n)
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+}
+
+// wait 阻塞到拿到n个令牌,ctx取消时归还预定并返回ctx的错误
+func (l *limiter) wait(ctx context.Context, n int) error {
+	d := l.reserve(n)
+	if d == 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.unreserve(n)
+		return ctx.Err()
+	}
+}
+
+// AssignN 同AssignCtx,但每个任务在派发时要从限流器拿n个令牌,没有开启限流时等同于AssignCtx,n小于0时拒绝
+func (p *pool) AssignN(ctx context.Context, n int, fs ...CtxTaskFunc) []Receipt {
+	return assign(p.submit, ctx, n, fs)
+}
+
+// 派发前从限流器拿令牌,不够时不拿,返回还要等的时间,等的时候任务留在队列里,worker也不算忙
+func (c *core) tokens(t *task) time.Duration {
+	if c.limit == nil || t.cost == 0 {
+		return 0
+	}
+	return c.limit.take(t.cost)
+}
+
+// 拿了令牌的任务最后没交给worker,令牌还回去
+func (c *core) untoken(t *task) {
+	if c.limit != nil && t.cost > 0 {
+		c.limit.unreserve(t.cost)
+	}
+}
+
+// 在调用方协程上执行的任务不进队列,直接在调用方等令牌
+func (c *core) throttle(ctx context.Context, t *task) error {
+	if c.limit == nil || t.cost == 0 {
+		return nil
+	}
+	return c.limit.wait(ctx, t.cost)
+}
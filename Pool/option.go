@@ -0,0 +1,161 @@
+package Pool
+
+import "time"
+
+// DefaultQueueSize 默认的任务队列容量
+const DefaultQueueSize = 1024
+
+// Policy 队列满时对新任务的处理策略
+type Policy int
+
+const (
+	Block      Policy = iota //阻塞调用方,直到队列有空位
+	Reject                   //直接拒绝,回执中带上ErrQueueFull
+	CallerRuns               //在调用方的协程上直接执行
+	DropOldest               //丢弃队首最老的任务,给新任务腾位置
+)
+
+// Option 线程池的可选配置,在New的时候传入
+type Option func(c *config)
+
+// WithQueue 设置任务队列的容量和队列满时的策略,size不大于0则使用默认容量
+func WithQueue(size int, policy Policy) Option {
+	return func(c *config) {
+		if size > 0 {
+			c.qcap = size
+		}
+		c.policy = policy
+	}
+}
+
+// WithPanicHandler 设置任务panic时的回调,默认打印panic值和调用栈
+func WithPanicHandler(h PanicHandler) Option {
+	return func(c *config) {
+		if h != nil {
+			c.onPanic = h
+		}
+	}
+}
+
+// WithAutoscale 开启自动伸缩,New的cap作为初始worker数,会被限制在[Min,Max]内
+func WithAutoscale(a Autoscale) Option {
+	return func(c *config) {
+		a.fix()
+		c.scale = &a
+	}
+}
+
+// WithExpvar 把Stats以name发布到expvar,可以从/debug/vars抓取
+func WithExpvar(name string) Option {
+	return func(c *config) {
+		c.expvar = name
+	}
+}
+
+// WithRateLimit 开启派发限流,每秒派发rate个令牌,最多攒burst个,普通任务消耗一个令牌,
+// 任务拿到令牌才出队交给worker,等令牌时worker空闲,优先级更高的任务照样可以插到前面
+func WithRateLimit(rate float64, burst int) Option {
+	return func(c *config) {
+		if rate > 0 && burst > 0 {
+			c.limit = newLimiter(rate, burst)
+		}
+	}
+}
+
+// WithWorkStealing 使用工作窃取调度,每个worker有自己的双端队列,任务里用自己的ctx提交的子任务
+// 进当前worker的队列,空闲的worker从别的队列偷任务,适合大量细小的任务,不支持自动伸缩
+func WithWorkStealing() Option {
+	return func(c *config) {
+		c.steal = true
+	}
+}
+
+// WithTimeout 设置任务默认的执行超时,到期取消任务的ctx,d不大于0表示不限时,
+// 超时后grace时间内任务还没返回就算掉队,不再计入Wait,它占着的worker由新的worker顶上,grace不大于0则使用DefaultGrace,
+// 在调用方协程上执行的任务(CallerRuns,组的Wait)同样超时取消,但掉队时调用方仍要等它返回
+func WithTimeout(d, grace time.Duration) Option {
+	return func(c *config) {
+		if d > 0 {
+			c.timeout = d
+		}
+		if grace > 0 {
+			c.grace = grace
+		}
+	}
+}
+
+// WithTimeoutHandler 设置任务超时和掉队时的回调
+func WithTimeoutHandler(h TimeoutHandler) Option {
+	return func(c *config) {
+		c.onTimeout = h
+	}
+}
+
+// WithTick 设置AssignAfter和AssignAt所用时间轮的刻度,到期时间按刻度向上取整,不大于0则使用DefaultTick
+func WithTick(d time.Duration) Option {
+	return func(c *config) {
+		if d > 0 {
+			c.tick = d
+		}
+	}
+}
+
+// WithPriority 按任务的优先级调度,worker总是先取优先级最高的任务,同优先级先进先出,
+// aging大于0时任务每等待aging优先级加一,持续有高优先级任务时低优先级的也不会饿死,不支持工作窃取调度
+func WithPriority(aging time.Duration) Option {
+	return func(c *config) {
+		c.prio = true
+		if aging > 0 {
+			c.aging = aging
+		}
+	}
+}
+
+// WithBudget 设置带权重任务的总预算,比如字节数,用Weight提交的任务要等余额够了才被接收,
+// 按提交顺序放行,权重超过size的直接拒绝,等待期间ctx取消也会拒绝
+func WithBudget(size int64) Option {
+	return func(c *config) {
+		if size > 0 {
+			c.budget = newBudget(size)
+		}
+	}
+}
+
+// WithTenants 按租户公平调度,用Tenant标记任务所属的租户,租户之间做差额轮转,每轮每个租户最多派发Weight个任务,
+// 正在执行的任务达到MaxInFlight的租户暂不派发,tenants里没有的租户使用def,不支持工作窃取调度和优先级调度
+func WithTenants(def TenantConfig, tenants map[string]TenantConfig) Option {
+	return func(c *config) {
+		c.fair = true
+		c.tenant = def
+		c.tenants = make(map[string]TenantConfig, len(tenants))
+		for name, cfg := range tenants {
+			c.tenants[name] = cfg
+		}
+	}
+}
+
+// WithWatchdog 让看门狗记录线程池执行的每个任务,执行超过阈值的任务由看门狗报告,
+// 同一个看门狗可以交给多个线程池,看门狗的停止由调用方负责
+func WithWatchdog(w Watchdog) Option {
+	return func(c *config) {
+		if w != nil {
+			c.watch = w.back()
+		}
+	}
+}
+
+// OnWorkerStart 每个worker启动时在自己的协程上调用f,返回值作为这个worker的本地值,
+// 任务通过WorkerLocal(ctx)取到,适合放解析器,缓冲区这类不能共享又不想每次都新建的资源
+func OnWorkerStart(f func() interface{}) Option {
+	return func(c *config) {
+		c.onStart = f
+	}
+}
+
+// OnWorkerStop worker退出前在自己的协程上调用f,传入它的本地值,关闭,Resize缩容,自动伸缩退休和掉队时都会调用,
+// 掉队的worker要等任务返回后才调用
+func OnWorkerStop(f func(local interface{})) Option {
+	return func(c *config) {
+		c.onStop = f
+	}
+}
@@ -0,0 +1,184 @@
+package Pool
+
+import (
+	"context"
+	"sync"
+)
+
+// Result Stream的单个结果
+type Result[R any] struct {
+	Index int //元素在输入里的序号,从0开始
+	Value R
+	Err   error
+}
+
+// MapOption Map,ForEach和Stream的可选配置
+type MapOption func(c *mapConfig)
+
+type mapConfig struct {
+	window    int  //同时在线程池里的元素上限
+	unordered bool //Stream按完成顺序输出
+}
+
+// Window 设置同时在线程池里(排队,执行中,以及Stream里还没被取走)的元素上限,默认是线程池worker数的两倍
+func Window(n int) MapOption {
+	return func(c *mapConfig) {
+		if n > 0 {
+			c.window = n
+		}
+	}
+}
+
+// Unordered Stream按完成顺序输出结果,默认按输入顺序
+func Unordered() MapOption {
+	return func(c *mapConfig) {
+		c.unordered = true
+	}
+}
+
+func newMapConfig(p Pool, opts []MapOption) mapConfig {
+	c := mapConfig{window: 2 * p.Len()}
+	if c.window <= 0 {
+		c.window = 1
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// 把一个元素的处理提交到线程池,run和fail恰好有一个会被调用
+func dispatch(ctx context.Context, p Pool, run func(ctx context.Context), fail func(err error)) {
+	t := newTask(ctx, run)
+	t.drop = fail
+	if r := sched(p).submit(t); r.Status == Rejected {
+		fail(r.Err)
+	}
+}
+
+// 调用f,panic时也通过report给出错误
+func apply[T, R any](f func(T) (R, error), v T, report func(R, error)) {
+	var zero R
+	defer catch(func(err error) { report(zero, err) })
+	report(f(v))
+}
+
+// Map 在线程池里对in的每个元素调用f,按输入顺序返回结果,
+// 第一个错误会取消还没开始的元素并作为返回值,同时在线程池里的元素不超过Window
+func Map[T, R any](ctx context.Context, p Pool, in []T, f func(T) (R, error), opts ...MapOption) ([]R, error) {
+	c := newMapConfig(p, opts)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	out := make([]R, len(in))
+	sem := make(chan struct{}, c.window)
+	var wg sync.WaitGroup
+	var once sync.Once
+	var first error
+	fail := func(err error) {
+		once.Do(func() {
+			first = err
+			cancel()
+		})
+	}
+
+	for i, v := range in {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			fail(ctx.Err())
+			break
+		}
+		i, v := i, v
+		wg.Add(1)
+		end := func() {
+			<-sem
+			wg.Done()
+		}
+		dispatch(ctx, p, func(context.Context) {
+			defer end()
+			apply(f, v, func(r R, err error) {
+				if err != nil {
+					fail(err)
+					return
+				}
+				out[i] = r
+			})
+		}, func(err error) {
+			defer end()
+			fail(err)
+		})
+	}
+	wg.Wait()
+	if first != nil {
+		return nil, first
+	}
+	return out, nil
+}
+
+// ForEach 在线程池里对in的每个元素调用f,返回第一个错误,其余同Map
+func ForEach[T any](ctx context.Context, p Pool, in []T, f func(T) error, opts ...MapOption) error {
+	_, err := Map(ctx, p, in, func(v T) (struct{}, error) { return struct{}{}, f(v) }, opts...)
+	return err
+}
+
+// Stream 在线程池里对in的每个元素调用f,结果从返回的chan里输出,默认按输入顺序,in关闭且全部输出后关闭,
+// 单个元素出错不影响其他元素,ctx取消后不再读取in,还没输出的结果被丢弃;
+// 元素从读取到结果被取走都算在Window里,下游不取结果时上游也会停下
+func Stream[T, R any](ctx context.Context, p Pool, in <-chan T, f func(T) (R, error), opts ...MapOption) <-chan Result[R] {
+	c := newMapConfig(p, opts)
+	out := make(chan Result[R])
+	sem := make(chan struct{}, c.window)
+	//有序时每个元素一个chan,按输入顺序排队;无序时共用一个chan,按完成顺序输出
+	//在途的元素不超过Window,所以往这些chan里写都不会阻塞worker
+	slots := make(chan chan Result[R], c.window)
+	done := make(chan Result[R], c.window)
+
+	go func() {
+		defer close(slots)
+		for i := 0; ; i++ {
+			var v T
+			var ok bool
+			select {
+			case v, ok = <-in:
+			case <-ctx.Done():
+			}
+			if !ok {
+				return
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			ch := done
+			if !c.unordered {
+				ch = make(chan Result[R], 1)
+			}
+			slots <- ch
+			i := i
+			dispatch(ctx, p, func(context.Context) {
+				apply(f, v, func(r R, err error) { ch <- Result[R]{Index: i, Value: r, Err: err} })
+			}, func(err error) {
+				ch <- Result[R]{Index: i, Err: err}
+			})
+		}
+	}()
+
+	go func() {
+		defer close(out)
+		for ch := range slots {
+			r := <-ch
+			if ctx.Err() == nil {
+				select {
+				case out <- r:
+				case <-ctx.Done():
+				}
+			}
+			<-sem
+		}
+	}()
+	return out
+}
@@ -0,0 +1,180 @@
+package Pool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pipeline 多级流水线,每一级有自己的并发数和缓冲,任务都在同一个线程池里执行,
+// 任意一级出错就取消整条流水线,下游停下时上游也会停下
+type Pipeline interface {
+	Wait() error         //等待所有级处理完,返回第一个错误
+	Cancel()             //取消整条流水线
+	Stats() []StageStats //按添加顺序返回每一级的统计
+	back() *pipeline
+}
+
+// Stage 流水线一级的配置
+type Stage struct {
+	Name    string
+	Workers int //同时处理的元素上限,不大于0时为1
+	Buffer  int //输出缓冲的大小
+}
+
+// StageStats 流水线一级的统计
+type StageStats struct {
+	Name       string
+	Processed  int64   //处理成功的元素数
+	Failed     int64   //处理失败的元素数
+	Busy       int     //正在处理的元素数
+	Backlog    int     //已处理完,等待下一级取走的元素数
+	Throughput float64 //从流水线开始到现在,平均每秒处理成功的元素数
+}
+
+// Pipe 流水线两级之间带类型的连接
+type Pipe[T any] struct {
+	pl *pipeline
+	ch <-chan T
+}
+
+// Chan 返回这一级的输出,最后一级不是Sink时由调用方读取
+func (p Pipe[T]) Chan() <-chan T {
+	return p.ch
+}
+
+// 流水线实体
+type pipeline struct {
+	p      Pool
+	ctx    context.Context
+	cancel context.CancelFunc
+	start  time.Time
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+
+	mu     sync.Mutex
+	stages []*stage
+}
+
+// 一级的运行时状态
+type stage struct {
+	name      string
+	processed int64
+	failed    int64
+	busy      int32
+	backlog   func() int
+}
+
+// NewPipeline 创建一条跑在p上的流水线,ctx取消时整条流水线停下
+func NewPipeline(ctx context.Context, p Pool) Pipeline {
+	pl := &pipeline{p: p, start: time.Now()}
+	pl.ctx, pl.cancel = context.WithCancel(ctx)
+	return pl
+}
+
+// Source 以in作为流水线的输入,in关闭后流水线逐级结束
+func Source[T any](pl Pipeline, in <-chan T) Pipe[T] {
+	return Pipe[T]{pl: pl.back(), ch: in}
+}
+
+// AddStage 在in后面加一级,f在线程池里执行,同一级内的元素不保证顺序
+func AddStage[In, Out any](in Pipe[In], s Stage, f func(ctx context.Context, v In) (Out, error)) Pipe[Out] {
+	out := make(chan Out, s.Buffer)
+	st := in.pl.add(s, func() int { return len(out) })
+	runStage(in.pl, st, in.ch, s, f, func(v Out) {
+		select {
+		case out <- v:
+		case <-in.pl.ctx.Done():
+		}
+	}, func() { close(out) })
+	return Pipe[Out]{pl: in.pl, ch: out}
+}
+
+// AddSink 以f作为流水线的最后一级,处理结果不再往下传
+func AddSink[T any](in Pipe[T], s Stage, f func(ctx context.Context, v T) error) {
+	st := in.pl.add(s, func() int { return 0 })
+	runStage(in.pl, st, in.ch, s, func(ctx context.Context, v T) (struct{}, error) {
+		return struct{}{}, f(ctx, v)
+	}, func(struct{}) {}, func() {})
+}
+
+func (pl *pipeline) add(s Stage, backlog func() int) *stage {
+	st := &stage{name: s.Name, backlog: backlog}
+	pl.mu.Lock()
+	pl.stages = append(pl.stages, st)
+	pl.mu.Unlock()
+	return st
+}
+
+// 启动一级:元素经Stream在线程池里处理,成功的交给emit,出错就取消整条流水线,全部结束后调用end
+func runStage[In, Out any](pl *pipeline, st *stage, in <-chan In, s Stage, f func(ctx context.Context, v In) (Out, error), emit func(Out), end func()) {
+	workers := s.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	res := Stream(pl.ctx, pl.p, in, func(v In) (Out, error) {
+		atomic.AddInt32(&st.busy, 1)
+		defer atomic.AddInt32(&st.busy, -1)
+		return f(pl.ctx, v)
+	}, Window(workers), Unordered())
+
+	pl.wg.Add(1)
+	go func() {
+		defer pl.wg.Done()
+		defer end()
+		for r := range res {
+			if r.Err != nil {
+				atomic.AddInt64(&st.failed, 1)
+				pl.fail(fmt.Errorf("stage %s: %w", st.name, r.Err))
+				continue
+			}
+			atomic.AddInt64(&st.processed, 1)
+			emit(r.Value)
+		}
+	}()
+}
+
+// 记下第一个错误并取消流水线
+func (pl *pipeline) fail(err error) {
+	pl.once.Do(func() {
+		pl.err = err
+		pl.cancel()
+	})
+}
+
+func (pl *pipeline) Wait() error {
+	pl.wg.Wait()
+	pl.fail(pl.ctx.Err())
+	return pl.err
+}
+
+func (pl *pipeline) Cancel() {
+	pl.cancel()
+}
+
+func (pl *pipeline) Stats() []StageStats {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	secs := time.Since(pl.start).Seconds()
+	ss := make([]StageStats, len(pl.stages))
+	for i, st := range pl.stages {
+		ss[i] = StageStats{
+			Name:      st.name,
+			Processed: atomic.LoadInt64(&st.processed),
+			Failed:    atomic.LoadInt64(&st.failed),
+			Busy:      int(atomic.LoadInt32(&st.busy)),
+			Backlog:   st.backlog(),
+		}
+		if secs > 0 {
+			ss[i].Throughput = float64(ss[i].Processed) / secs
+		}
+	}
+	return ss
+}
+
+func (pl *pipeline) back() *pipeline {
+	return pl
+}
@@ -1,113 +1,594 @@
 package Pool
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"runtime/debug"
 	"sync"
+	"time"
 )
 
 // TaskFunc 用来标记任务的函数
 type TaskFunc func()
 
+// CtxTaskFunc 带上下文的任务函数,ctx继承提交时传入的上下文,取消时任务会收到信号,
+// 任务返回,并且用这个ctx提交的子任务都结束后ctx才取消,子任务不会因为父任务先返回而被丢弃
+type CtxTaskFunc func(ctx context.Context)
+
 // Pool 线程池接口
 type Pool interface {
 	Len() int
-	Assign(fs ...TaskFunc)
-	Wait()
-	Trigger()
-	Now() int //返回当前空闲数
+	Assign(fs ...TaskFunc) []Receipt //返回每个任务的提交回执,顺序与fs一致
+	AssignCtx(ctx context.Context, fs ...CtxTaskFunc) []Receipt
+	AssignN(ctx context.Context, n int, fs ...CtxTaskFunc) []Receipt           //每个任务派发时消耗n个令牌
+	AssignWith(ctx context.Context, f CtxTaskFunc, opts ...TaskOption) Receipt //按opts单独配置任务,比如Timeout
+	AssignKeyed(key string, fs ...TaskFunc) []Receipt                          //同一个key的任务按提交顺序逐个执行,不同key之间并行
+	AssignAfter(d time.Duration, f TaskFunc) Timer                             //d之后提交f,返回可以取消的句柄
+	AssignAt(at time.Time, f TaskFunc) Timer                                   //在at时刻提交f
+	Group(ctx context.Context, opts ...GroupOption) Group                      //创建有独立Wait和错误汇总的任务组
+	Wait()                                                                     //开启限流时排队的任务按限速派发,Wait会一直等到它们执行完
+	WaitCtx(ctx context.Context) error
+	Resize(n int) error                 //调整worker数量,自动伸缩模式下会被限制在[Min,Max]内
+	Shutdown(ctx context.Context) error //不再接收新任务,等待排队和执行中的任务完成
+	ShutdownNow() []CtxTaskFunc         //不再接收新任务,取消执行中的任务,返回还没开始的任务
+	Now() int                           //返回当前正在执行任务的worker数
+	Panics() int64                      //返回累计panic的任务数
+	Stats() Stats                       //返回运行状态快照
+}
+
+// scheduler 包内两种线程池都实现的调度入口,Future,Group这类帮助函数通过sched拿到
+type scheduler interface {
+	Pool
+	submit(t *task) Receipt
+	callerRun(t *task) Receipt
+	hold()          //让Wait多等一个不在线程池里的任务,比如等待重试的
+	release()       //hold的任务结束
+	onWorker() bool //调用方是不是这个线程池的worker协程
+}
+
+// sched 取线程池的调度入口,包外实现的Pool(比如测试用的假实现)只能走公开方法:
+// 任务被它丢弃时拿不到通知,Wait也不会等待重试的间隔
+func sched(p Pool) scheduler {
+	if s, ok := p.(scheduler); ok {
+		return s
+	}
+	return foreign{p}
+}
+
+// foreign 包外实现的Pool
+type foreign struct {
+	Pool
+}
+
+func (f foreign) submit(t *task) Receipt {
+	return f.AssignWith(t.ctx, t.fn)
+}
+
+// 在调用方协程上执行,panic交给默认的处理函数
+func (f foreign) callerRun(t *task) (r Receipt) {
+	r.Status = CallerRan
+	defer func() {
+		if v := recover(); v != nil {
+			printPanic(v, debug.Stack())
+		}
+	}()
+	t.fn(t.ctx)
+	return r
+}
+
+func (f foreign) hold() {}
+
+func (f foreign) onWorker() bool {
+	return false
+}
+
+func (f foreign) release() {}
+
+// Status 任务的提交结果
+type Status int
+
+const (
+	Queued    Status = iota //已进入队列
+	Displaced               //已进入队列,但挤掉了队首最老的任务
+	CallerRan               //队列已满,已在调用方的协程上执行完毕
+	Rejected                //未被接收,原因见Receipt.Err
+)
+
+// Receipt 单个任务的提交回执
+type Receipt struct {
+	Status Status
+	Err    error
+}
+
+var (
+	ErrQueueFull  = errors.New("pool queue is full")
+	ErrPoolClosed = errors.New("pool is shut down")
+	ErrDropped    = errors.New("task dropped from full queue")
+	ErrBadSize    = errors.New("pool size must be positive")
+	ErrOverBurst  = errors.New("task cost exceeds rate limit burst")
+	ErrBadCost    = errors.New("task cost must not be negative")
+	ErrOverBudget = errors.New("task weight exceeds pool budget")
+)
+
+// PanicHandler 任务panic后的处理函数,v为recover到的值,stack为panic时的调用栈
+type PanicHandler func(v interface{}, stack []byte)
+
+// PanicError 任务panic时Future拿到的错误
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("task panic: %v", e.Value)
+}
+
+// catch 在任务函数里defer调用,panic时先把PanicError交给report,再接着panic,让线程池去计数和回调
+func catch(report func(err error)) {
+	if v := recover(); v != nil {
+		report(&PanicError{Value: v, Stack: debug.Stack()})
+		panic(v)
+	}
+}
+
+// 默认的panic处理,打印出来就算完事
+func printPanic(v interface{}, stack []byte) {
+	fmt.Printf("task panic: %v\n%s\n", v, stack)
 }
 
 type worker struct {
-	task     TaskFunc
-	isAssign bool
+	task      *task
+	isAssign  bool
+	idleSince time.Time   //最近一次进入空闲的时间,自动伸缩时用来判断是否退休
+	value     interface{} //OnWorkerStart返回的本地值
+	gid       uint64      //worker协程的id
+}
+
+// localKey 任务ctx里存放worker本地值的键
+type localKey struct{}
+
+// WorkerLocal 取执行当前任务的worker的本地值,没有设置OnWorkerStart或者任务不在worker上执行(比如CallerRuns)时返回nil
+func WorkerLocal(ctx context.Context) interface{} {
+	return ctx.Value(localKey{})
+}
+
+func (w *worker) Do(t *task) {
+	w.task = t
+	defer func() { w.task = nil }()
+	t.fn(t.ctx)
 }
 
-func (w *worker) Do(f TaskFunc) {
-	w.task = f
-	w.task()
-	w.task = nil
+// grave 被丢弃的任务和丢弃原因
+type grave struct {
+	t   *task
+	err error
 }
 
+// 默认实现,所有worker共享一个队列
 type pool struct {
-	workers []worker       //任务队列
-	cap     int            //线程池容量
-	live    bool           //线程池状态
-	wg      sync.WaitGroup //等待组
+	core
+	mu       sync.Mutex           //保护队列和worker状态
+	notEmpty *sync.Cond           //队列来了新任务的信号,空闲worker在上面等待
+	notFull  *sync.Cond           //队列腾出空位的信号,Block策略下使用
+	workers  map[*worker]struct{} //常驻的worker,数量即并发上限
+	queue    queue                //等待执行的任务
+	cap      int                  //线程池容量,即worker的目标数量
+	busy     int                  //正在执行任务的worker数
+	closed   bool                 //是否已经关闭,关闭后不再接收新任务
+	graves   []grave              //持锁期间被丢弃的任务,解锁后处理
+	pending  int                  //已接收但还没执行完的任务数
+	idle     chan struct{}        //pending归零时关闭,用来实现Wait
 }
 
-func New(cap int) Pool {
+func New(cap int, opts ...Option) Pool {
 
 	if cap <= 0 {
 		fmt.Println(" wrong cap ! please again ")
 		return nil
 	}
 
+	c := defaultConfig()
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.prio && c.fair {
+		fmt.Println(" pool can't schedule by both priority and tenant ! please again ")
+		return nil
+	}
+	if c.steal {
+		if c.scale != nil {
+			fmt.Println(" work stealing pool can't autoscale ! please again ")
+			return nil
+		}
+		if c.prio {
+			fmt.Println(" work stealing pool can't schedule by priority ! please again ")
+			return nil
+		}
+		if c.fair {
+			fmt.Println(" work stealing pool can't schedule by tenant ! please again ")
+			return nil
+		}
+		return newStealPool(cap, c)
+	}
+	return newPool(cap, c)
+}
+
+func newPool(cap int, c config) *pool {
 	p := &pool{
-		workers: make([]worker, cap),
+		workers: make(map[*worker]struct{}, cap),
+		queue:   newFifo(),
 		cap:     cap,
-		live:    true,
+		idle:    make(chan struct{}),
+	}
+	p.init(c)
+	switch {
+	case c.prio:
+		p.queue = newPrioq(c.aging)
+	case c.fair:
+		p.queue = newFairq(c.tenant, c.tenants)
+	}
+	close(p.idle)
+	p.notEmpty = sync.NewCond(&p.mu)
+	p.notFull = sync.NewCond(&p.mu)
+	if p.scale != nil {
+		p.cap = p.scale.clamp(p.cap)
+		go p.autoscale()
+	}
+	if p.expvar != "" {
+		publish(p.expvar, p.Stats)
 	}
+	//worker常驻,每个worker一个协程,共享同一个队列
+	p.mu.Lock()
+	for i := 0; i < p.cap; i++ {
+		p.spawn()
+	}
+	p.mu.Unlock()
 
 	return p
 }
 
-func (p *pool) Assign(fs ...TaskFunc) {
-	if !p.live {
+// Assign 把任务放进共享队列,由常驻的worker依次取出执行,队列满时按Policy处理
+func (p *pool) Assign(fs ...TaskFunc) []Receipt {
+	return assign(p.submit, context.Background(), 1, wrap(fs))
+}
+
+// AssignCtx 同Assign,ctx取消后还在排队的任务会被移出队列,正在执行的任务通过ctx收到信号
+func (p *pool) AssignCtx(ctx context.Context, fs ...CtxTaskFunc) []Receipt {
+	return assign(p.submit, ctx, 1, fs)
+}
+
+// 逐个包装成任务提交,cost为每个任务派发时消耗的令牌数
+func assign(submit func(t *task) Receipt, ctx context.Context, cost int, fs []CtxTaskFunc) []Receipt {
+	rs := make([]Receipt, len(fs))
+	for i, f := range fs {
+		t := newTask(ctx, f)
+		t.cost = cost
+		rs[i] = submit(t)
+	}
+	return rs
+}
+
+// AssignWith 提交单个任务,opts对这个任务单独生效
+func (p *pool) AssignWith(ctx context.Context, f CtxTaskFunc, opts ...TaskOption) Receipt {
+	return p.submit(newTask(ctx, f).with(opts))
+}
+
+// 把TaskFunc包装成CtxTaskFunc
+func wrap(fs []TaskFunc) []CtxTaskFunc {
+	cfs := make([]CtxTaskFunc, len(fs))
+	for i, f := range fs {
+		f := f
+		cfs[i] = func(context.Context) { f() }
+	}
+	return cfs
+}
+
+// 提交单个任务的内核
+func (p *pool) submit(t *task) (r Receipt) {
+	if err := p.admit(t); err != nil {
+		return p.reject(err)
+	}
+	if err := p.weigh(t); err != nil {
+		return p.reject(err)
+	}
+	defer func() {
+		if r.Status == Rejected {
+			p.unweigh(t)
+		}
+	}()
+	var stop func() bool
+	defer func() {
+		if stop != nil {
+			stop()
+		}
+	}()
+
+	p.mu.Lock()
+	status := Queued
+	for !p.closed && !t.follow && t.ctx.Err() == nil && p.queue.len() >= p.qcap {
+		switch p.policy {
+		case Reject:
+			p.unlock()
+			return p.reject(ErrQueueFull)
+		case CallerRuns:
+			p.unlock()
+			return p.callerRun(t)
+		case DropOldest:
+			p.discard(p.queue.evict(), ErrDropped)
+			status = Displaced
+		default:
+			//ctx取消时要把自己从等待中唤醒
+			if stop == nil && t.ctx.Done() != nil {
+				stop = context.AfterFunc(t.ctx, func() {
+					p.mu.Lock()
+					p.notFull.Broadcast()
+					p.mu.Unlock()
+				})
+			}
+			p.notFull.Wait()
+		}
+	}
+	if p.closed && !p.resumable(t) {
+		p.unlock()
+		return p.reject(ErrPoolClosed)
+	}
+	if err := t.ctx.Err(); err != nil {
+		p.unlock()
+		return p.reject(err)
+	}
+
+	p.enqueue(t)
+	p.unlock()
+	return Receipt{Status: status}
+}
+
+// 任务入队,调用方需持有锁
+func (p *pool) enqueue(t *task) {
+	p.add()
+	t.at = time.Now()
+	t.scope = scopeOf(t.ctx).acquire()
+	p.queue.push(t)
+	if t.ctx.Done() != nil {
+		t.stop = context.AfterFunc(t.ctx, func() { p.cancel(t) })
+	}
+	p.notEmpty.Signal()
+	if p.scale != nil && p.scale.QueueDepth > 0 && p.queue.len() > p.scale.QueueDepth {
+		p.grow()
+	}
+}
+
+// ctx取消后把还在排队的任务移出队列
+func (p *pool) cancel(t *task) {
+	p.mu.Lock()
+	defer p.unlock()
+	if p.queue.remove(t) {
+		p.discard(t, t.ctx.Err())
+		p.notFull.Signal()
+	}
+}
+
+// 丢弃一个已出队但不再执行的任务,err为丢弃原因,调用方需持有锁,
+// 丢弃回调可能会再提交任务,所以留到unlock里在锁外执行
+func (p *pool) discard(t *task, err error) {
+	p.graves = append(p.graves, grave{t: t, err: err})
+}
+
+// 解锁,然后在锁外处理持锁期间被丢弃的任务
+func (p *pool) unlock() {
+	gs := p.graves
+	p.graves = nil
+	p.mu.Unlock()
+	if len(gs) == 0 {
 		return
 	}
-	//遍历空闲worker队列,执行一次异步分配
-	for i := range p.workers {
+	for _, g := range gs {
+		p.drop(g.t, g.err)
+	}
+	p.mu.Lock()
+	for range gs {
+		p.done()
+	}
+	p.mu.Unlock()
+}
 
-		w := &p.workers[i]
+// 多一个任务,调用方需持有锁
+func (p *pool) add() {
+	if p.pending == 0 {
+		p.idle = make(chan struct{})
+	}
+	p.pending++
+}
+
+func (p *pool) hold() {
+	p.mu.Lock()
+	p.add()
+	p.mu.Unlock()
+}
 
-		if !w.isAssign {
+func (p *pool) release() {
+	p.mu.Lock()
+	p.done()
+	p.mu.Unlock()
+}
 
-			w.isAssign = true //标记为已分配
+// 一个任务结束,调用方需持有锁
+func (p *pool) done() {
+	p.pending--
+	if p.pending == 0 {
+		close(p.idle)
+		//关闭后还留着等重试的worker,现在可以退出了
+		if p.closed {
+			p.notEmpty.Broadcast()
+		}
+	}
+}
 
-			p.wg.Add(len(fs))
+// 启动一个新worker,调用方需持有锁
+func (p *pool) spawn() {
+	w := &worker{idleSince: time.Now()}
+	p.workers[w] = struct{}{}
+	p.live.Add(1)
+	go p.work(w)
+}
 
-			for _, f := range fs {
-				go func() {
+// worker常驻循环,队列为空时挂起等待新任务,被裁撤时退出
+func (p *pool) work(w *worker) {
+	p.enter(w)
+	abandon := func() { p.abandon(w) }
+	for {
+		p.mu.Lock()
+		t := p.next(w)
+		if t == nil {
+			p.unlock()
+			p.leave(w, true)
+			return
+		}
+		w.isAssign = true
+		p.busy++
+		p.unlock()
 
-					defer func() {
-						w.isAssign = false
-						p.wg.Done()
-					}()
+		if p.run(w, t, abandon) == errStraggler {
+			//掉队的任务终于返回了,它占的租户名额交给别的worker
+			p.mu.Lock()
+			p.queue.done(t)
+			p.notEmpty.Signal()
+			p.mu.Unlock()
+			p.leave(w, false)
+			return
+		}
 
-					w.Do(f)
+		p.mu.Lock()
+		p.queue.done(t)
+		w.isAssign = false
+		w.idleSince = time.Now()
+		p.busy--
+		p.done()
+		p.unlock()
+	}
+}
 
-				}()
-			}
+// 放弃执行掉队任务的worker,任务不再计入Wait,另起一个worker顶上
+func (p *pool) abandon(w *worker) {
+	p.disown(w)
+	p.mu.Lock()
+	delete(p.workers, w)
+	p.busy--
+	p.done()
+	if len(p.workers) < p.cap {
+		p.spawn()
+	}
+	p.mu.Unlock()
+	p.live.Done()
+}
 
-			return //结束分配
+// 取出下一个可执行的任务,没有就挂起等待,开启限流时拿到令牌才出队,worker需要退出时返回nil,调用方需持有锁
+func (p *pool) next(w *worker) *task {
+	for {
+		//worker比目标数量多,先到这里的退出;关闭后队列空了,也没有等待重试之类还会再提交的任务,全部退出
+		if len(p.workers) > p.cap || p.closed && p.queue.len() == 0 && p.finished() {
+			delete(p.workers, w)
+			return nil
+		}
+		t := p.queue.peek()
+		if t == nil {
+			p.notEmpty.Wait()
+			continue
+		}
+		//等令牌时任务留在队列里,期间来了优先级更高的任务照样先派发,ctx取消的照样移出队列
+		if d := p.tokens(t); d > 0 {
+			p.mu.Unlock()
+			time.Sleep(d)
+			p.mu.Lock()
+			continue
+		}
+		p.queue.take()
+		p.notFull.Signal()
+		if t.stop != nil {
+			t.stop()
+		}
+		//出队前ctx已经取消的任务不再执行
+		if err := t.ctx.Err(); err != nil {
+			p.untoken(t)
+			p.queue.done(t)
+			p.discard(t, err)
+			continue
 		}
+		return t
 	}
 }
 
+// 关闭后是否不会再有任务入队:pending归零,或者ShutdownNow之后后续任务都会被拒绝,调用方需持有锁
+func (p *pool) finished() bool {
+	return p.pending == 0 || p.ctx.Err() != nil
+}
+
 // Wait 同步阻塞主线程,等待所有worker完成任务
 func (p *pool) Wait() {
-	p.wg.Wait()
+	_ = p.WaitCtx(context.Background())
+}
+
+// WaitCtx 同Wait,ctx取消时提前返回ctx的错误
+func (p *pool) WaitCtx(ctx context.Context) error {
+	p.mu.Lock()
+	idle := p.idle
+	p.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-// Trigger 若线程池活跃则关闭,若关闭则开启
-func (p *pool) Trigger() {
-	if p.live {
-		p.live = false
-	} else {
-		p.live = true
+// Shutdown 不再接收新任务,等待排队和执行中的任务全部完成,再等worker退出并执行完OnWorkerStop,
+// ctx到期时返回ctx的错误,剩下的任务照常执行
+func (p *pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	p.close()
+	p.mu.Unlock()
+	if err := p.WaitCtx(ctx); err != nil {
+		return err
 	}
+	return p.drain(ctx)
+}
+
+// ShutdownNow 不再接收新任务,取消执行中任务的ctx,返回还在排队的任务
+func (p *pool) ShutdownNow() []CtxTaskFunc {
+	p.kill()
+	p.mu.Lock()
+	p.close()
+	//先Shutdown再ShutdownNow时close不会再唤醒,留着等重试的worker要在这里叫醒
+	p.notEmpty.Broadcast()
+	fs := make([]CtxTaskFunc, 0, p.queue.len())
+	for t := p.queue.pop(); t != nil; t = p.queue.pop() {
+		fs = append(fs, t.fn)
+		p.discard(t, ErrPoolClosed)
+	}
+	p.unlock()
+	return fs
+}
+
+// 标记关闭并唤醒所有等待的协程,调用方需持有锁
+func (p *pool) close() {
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.quit)
+	//阻塞在队列上的调用方拿到拒绝回执,空闲的worker退出
+	p.notFull.Broadcast()
+	p.notEmpty.Broadcast()
 }
 
 func (p *pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	return len(p.workers)
 }
 
 func (p *pool) Now() int {
-	count := 0
-	for _, w := range p.workers {
-		if w.isAssign {
-			count++
-		}
-	}
-	return count
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.busy
 }
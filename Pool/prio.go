@@ -0,0 +1,144 @@
+package Pool
+
+import (
+	"container/heap"
+	"time"
+)
+
+// 常用的几档优先级,数字越大越先执行,也可以直接用别的整数
+const (
+	PriorityBackground = -10
+	PriorityNormal     = 0
+	PriorityCritical   = 10
+)
+
+// Priority 设置任务的优先级,默认为PriorityNormal,只在开启了WithPriority的线程池里生效
+func Priority(n int) TaskOption {
+	return func(t *task) {
+		t.priority = n
+	}
+}
+
+// prioq 按优先级出队的队列,同优先级先进先出,开启老化后等待越久优先级越高
+type prioq struct {
+	h     taskHeap
+	aging time.Duration //每等待这么久优先级加一,0表示不老化
+	seq   uint64        //入队序号,排名相同时先进先出
+	depth map[int]int   //每个优先级排队的任务数
+}
+
+func newPrioq(aging time.Duration) *prioq {
+	return &prioq{aging: aging, depth: make(map[int]int)}
+}
+
+func (q *prioq) push(t *task) {
+	//所有任务按同样的速度老化,所以排名可以在入队时算好:入队时间往前挪priority个老化周期
+	if q.aging > 0 {
+		t.rank = t.at.UnixNano() - int64(t.priority)*int64(q.aging)
+	} else {
+		t.rank = -int64(t.priority)
+	}
+	q.seq++
+	t.seq = q.seq
+	heap.Push(&q.h, t)
+	q.depth[t.priority]++
+}
+
+func (q *prioq) pop() *task {
+	if len(q.h) == 0 {
+		return nil
+	}
+	t := heap.Pop(&q.h).(*task)
+	q.forget(t)
+	return t
+}
+
+// evict 挤掉最后才会执行的任务,即排名最低的,排名相同时挤掉最老的,不能用pop,否则挤掉的是优先级最高的
+func (q *prioq) evict() *task {
+	if len(q.h) == 0 {
+		return nil
+	}
+	at := 0
+	for i, t := range q.h {
+		if v := q.h[at]; t.rank > v.rank || t.rank == v.rank && t.seq < v.seq {
+			at = i
+		}
+	}
+	t := heap.Remove(&q.h, at).(*task)
+	q.forget(t)
+	return t
+}
+
+func (q *prioq) take() *task {
+	return q.pop()
+}
+
+func (q *prioq) done(*task) {}
+
+func (q *prioq) peek() *task {
+	if len(q.h) == 0 {
+		return nil
+	}
+	return q.h[0]
+}
+
+func (q *prioq) remove(t *task) bool {
+	if t.index < 0 || t.index >= len(q.h) || q.h[t.index] != t {
+		return false
+	}
+	heap.Remove(&q.h, t.index)
+	q.forget(t)
+	return true
+}
+
+func (q *prioq) len() int {
+	return len(q.h)
+}
+
+func (q *prioq) forget(t *task) {
+	if q.depth[t.priority]--; q.depth[t.priority] == 0 {
+		delete(q.depth, t.priority)
+	}
+}
+
+// 每个优先级排队的任务数
+func (q *prioq) depths() map[int]int {
+	m := make(map[int]int, len(q.depth))
+	for k, v := range q.depth {
+		m[k] = v
+	}
+	return m
+}
+
+// taskHeap 按rank和seq排序的小顶堆
+type taskHeap []*task
+
+func (h taskHeap) Len() int { return len(h) }
+
+func (h taskHeap) Less(i, j int) bool {
+	if h[i].rank != h[j].rank {
+		return h[i].rank < h[j].rank
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h taskHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *taskHeap) Push(x interface{}) {
+	t := x.(*task)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	t := old[len(old)-1]
+	old[len(old)-1] = nil //断开引用,方便gc
+	t.index = -1
+	*h = old[:len(old)-1]
+	return t
+}
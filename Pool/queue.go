@@ -0,0 +1,155 @@
+package Pool
+
+import (
+	"context"
+	"time"
+)
+
+// task 队列中的任务单元
+type task struct {
+	ctx      context.Context
+	fn       CtxTaskFunc
+	stop     func() bool   //注销ctx取消时的回调
+	at       time.Time     //入队时间
+	drop     func(error)   //没执行就被丢弃时的回调
+	err      error         //任务自己报告的错误,Future会写入,用于统计失败数
+	cost     int           //派发时要从限流器拿的令牌数
+	state    int32         //工作窃取调度下的认领状态,原子操作
+	follow   bool          //keyed任务的后续任务,不受队列容量限制,Shutdown后也能提交
+	timeout  time.Duration //执行超时,0表示用线程池的默认值,小于0表示不限时
+	priority int           //优先级,越大越先执行
+	tenant   string        //所属租户
+	rank     int64         //在优先级队列里的排名,越小越先执行
+	seq      uint64        //进优先级队列的序号
+	index    int           //在优先级队列堆里的下标
+	weight   int64         //占用的预算
+	held     int64         //已经拿到还没归还的预算
+	scope    *scope        //提交它的执行中任务的scope,执行或者丢弃时释放
+}
+
+func newTask(ctx context.Context, fn CtxTaskFunc) *task {
+	return &task{ctx: ctx, fn: fn, cost: 1}
+}
+
+func (t *task) with(opts []TaskOption) *task {
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// queue 任务队列,由pool的锁保护,本身不做同步
+type queue interface {
+	push(t *task)
+	pop() *task   //弹出下一个要执行的任务,队列为空返回nil
+	evict() *task //DropOldest时挤掉一个任务,队列为空返回nil
+	take() *task  //弹出下一个可以交给worker的任务,没有返回nil
+	done(t *task) //take出来的任务执行完或者被丢弃
+	peek() *task  //查看take会取出的任务,不出队
+	remove(t *task) bool
+	len() int
+}
+
+// fifo 环形缓冲实现的先进先出队列,容量不够时自动扩容,上限由pool控制
+type fifo struct {
+	buf  []*task
+	head int
+	size int
+}
+
+func newFifo() *fifo {
+	return &fifo{buf: make([]*task, 16)}
+}
+
+func (q *fifo) push(t *task) {
+	if q.size == len(q.buf) {
+		q.grow()
+	}
+	q.buf[(q.head+q.size)%len(q.buf)] = t
+	q.size++
+}
+
+// evict 挤掉最老的任务,即队首
+func (q *fifo) evict() *task {
+	return q.pop()
+}
+
+func (q *fifo) pop() *task {
+	if q.size == 0 {
+		return nil
+	}
+	t := q.buf[q.head]
+	q.buf[q.head] = nil //断开引用,方便gc
+	q.head = (q.head + 1) % len(q.buf)
+	q.size--
+	return t
+}
+
+func (q *fifo) take() *task {
+	return q.pop()
+}
+
+func (q *fifo) done(*task) {}
+
+func (q *fifo) peek() *task {
+	if q.size == 0 {
+		return nil
+	}
+	return q.buf[q.head]
+}
+
+// pushFront 放回队首,工作窃取调度下从队首取出的任务没拿到令牌时用
+func (q *fifo) pushFront(t *task) {
+	if q.size == len(q.buf) {
+		q.grow()
+	}
+	q.head = (q.head - 1 + len(q.buf)) % len(q.buf)
+	q.buf[q.head] = t
+	q.size++
+}
+
+// popBack 从队尾弹出最新的任务,工作窃取调度下worker自己用
+func (q *fifo) popBack() *task {
+	if q.size == 0 {
+		return nil
+	}
+	i := (q.head + q.size - 1) % len(q.buf)
+	t := q.buf[i]
+	q.buf[i] = nil
+	q.size--
+	return t
+}
+
+// remove 移除指定任务,后面的任务依次前移
+func (q *fifo) remove(t *task) bool {
+	n := len(q.buf)
+	for i := 0; i < q.size; i++ {
+		if q.buf[(q.head+i)%n] != t {
+			continue
+		}
+		for j := i; j < q.size-1; j++ {
+			q.buf[(q.head+j)%n] = q.buf[(q.head+j+1)%n]
+		}
+		q.buf[(q.head+q.size-1)%n] = nil
+		q.size--
+		return true
+	}
+	return false
+}
+
+func (q *fifo) len() int {
+	return q.size
+}
+
+func (q *fifo) grow() {
+	n := len(q.buf) * 2
+	if n == 0 {
+		n = 16
+	}
+	buf := make([]*task, n)
+	for i := 0; i < q.size; i++ {
+		buf[i] = q.buf[(q.head+i)%len(q.buf)]
+	}
+	q.buf = buf
+	q.head = 0
+}
@@ -0,0 +1,563 @@
+package Pool
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrCoordinatorClosed = errors.New("coordinator is closed")
+	ErrWorkerLost        = errors.New("remote worker lost too many times")
+	ErrNoHandlers        = errors.New("remote worker has no handlers")
+	errUnregistered      = errors.New("remote worker not registered or expired")
+)
+
+// 远程任务的默认配置
+const (
+	DefaultLease   = 5 * time.Second //worker这么久没有消息就当它没了
+	DefaultRequeue = 3               //worker没了时任务最多重新排队的次数
+)
+
+// RemoteHandler 远程worker上按任务名注册的处理函数,args和返回值都是序列化好的数据
+type RemoteHandler func(ctx context.Context, args []byte) ([]byte, error)
+
+// RemoteTask 派发给远程worker的任务
+type RemoteTask struct {
+	ID   uint64
+	Name string
+	Args []byte
+}
+
+// RemoteResult 远程worker回报的执行结果,Err为空表示成功
+type RemoteResult struct {
+	ID    uint64
+	Value []byte
+	Err   string
+}
+
+// Coordinator 把任务派发给通过net/rpc连上来的远程worker,worker断开或者心跳超时时,
+// 它手上的任务重新排队交给别的worker,所以同一个任务可能执行不止一次
+type Coordinator interface {
+	Assign(ctx context.Context, name string, args []byte) Future[[]byte] //排队等有对应处理函数的worker来取
+	Workers() int                                                        //在线的worker数
+	Pending() int                                                        //排队和执行中的任务数
+	Addr() net.Addr
+	Close() error //断开所有worker,没完成的任务返回ErrCoordinatorClosed
+}
+
+// CoordinatorOption Coordinator的配置项
+type CoordinatorOption func(c *coordinator)
+
+// WithLease 设置worker的租约,worker每隔lease/3发一次心跳,超过lease没有消息就当它没了
+func WithLease(d time.Duration) CoordinatorOption {
+	return func(c *coordinator) {
+		if d > 0 {
+			c.lease = d
+		}
+	}
+}
+
+// WithRequeue 设置worker没了时任务最多重新排队的次数,超过后任务返回ErrWorkerLost,小于0表示不限
+func WithRequeue(n int) CoordinatorOption {
+	return func(c *coordinator) {
+		c.requeue = n
+	}
+}
+
+// 协调端实体
+type coordinator struct {
+	l       net.Listener
+	lease   time.Duration
+	requeue int
+
+	mu      sync.Mutex
+	queue   *list.List //排队的*remoteTask
+	wake    chan struct{}
+	next    uint64 //下一个任务id
+	seq     uint64 //下一个worker id
+	workers map[uint64]*session
+	conns   map[net.Conn]*session //所有连接,包括还没Register的
+	closed  bool
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// 协调端记录的一个任务
+type remoteTask struct {
+	RemoteTask
+	fu    *future[[]byte]
+	elem  *list.Element //排队时在队列里的位置
+	owner *session      //执行中时所在的worker
+	lost  int           //因为worker没了重新排队的次数
+}
+
+// session 一个worker连接,它导出的方法就是worker能调用的rpc
+type session struct {
+	c        *coordinator
+	conn     net.Conn
+	id       uint64
+	names    map[string]bool
+	seen     time.Time //最近一次收到消息的时间,还没Register时为连上的时间
+	inflight map[uint64]*remoteTask
+	gone     bool
+}
+
+// NewCoordinator 在l上接收worker连接,l可以是tcp也可以是unix socket,Close时会关闭l
+func NewCoordinator(l net.Listener, opts ...CoordinatorOption) Coordinator {
+	c := &coordinator{
+		l:       l,
+		lease:   DefaultLease,
+		requeue: DefaultRequeue,
+		queue:   list.New(),
+		wake:    make(chan struct{}),
+		workers: make(map[uint64]*session),
+		conns:   make(map[net.Conn]*session),
+		quit:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.wg.Add(2)
+	go c.accept()
+	go c.reap()
+	return c
+}
+
+func (c *coordinator) accept() {
+	defer c.wg.Done()
+	for {
+		conn, err := c.l.Accept()
+		if err != nil {
+			select {
+			case <-c.quit:
+				return
+			default:
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			fmt.Printf("coordinator accept failed : %v\n", err)
+			return
+		}
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s := &session{c: c, conn: conn, seen: time.Now(), inflight: make(map[uint64]*remoteTask)}
+		c.conns[conn] = s
+		c.mu.Unlock()
+
+		c.wg.Add(1)
+		go c.serve(s)
+	}
+}
+
+// 每个连接一个rpc.Server,连接断开时就知道是哪个worker没了
+func (c *coordinator) serve(s *session) {
+	defer c.wg.Done()
+	conn := s.conn
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Coordinator", s); err != nil {
+		fmt.Printf("coordinator register rpc failed : %v\n", err)
+		conn.Close()
+		return
+	}
+	srv.ServeConn(conn)
+
+	c.mu.Lock()
+	delete(c.conns, conn)
+	lost := c.lose(s)
+	err := ErrWorkerLost
+	if c.closed {
+		err = ErrCoordinatorClosed
+	}
+	c.mu.Unlock()
+	c.fail(lost, err)
+}
+
+// 定期清理心跳超时的worker,连上后一个租约内没有Register的连接也断开
+func (c *coordinator) reap() {
+	defer c.wg.Done()
+	tk := time.NewTicker(c.lease / 4)
+	defer tk.Stop()
+	for {
+		select {
+		case <-c.quit:
+			return
+		case now := <-tk.C:
+			var lost []*remoteTask
+			c.mu.Lock()
+			for _, s := range c.conns {
+				if !s.gone && now.Sub(s.seen) > c.lease {
+					lost = append(lost, c.lose(s)...)
+					s.conn.Close()
+				}
+			}
+			c.mu.Unlock()
+			c.fail(lost, ErrWorkerLost)
+		}
+	}
+}
+
+// 移除worker,它手上的任务按id顺序放回队首,返回重排次数用完的任务,调用方需持有锁
+func (c *coordinator) lose(s *session) []*remoteTask {
+	if s.gone {
+		return nil
+	}
+	s.gone = true
+	delete(c.workers, s.id)
+	ts := make([]*remoteTask, 0, len(s.inflight))
+	for _, t := range s.inflight {
+		ts = append(ts, t)
+	}
+	s.inflight = nil
+	sort.Slice(ts, func(i, j int) bool { return ts[i].ID > ts[j].ID })
+
+	var lost []*remoteTask
+	for _, t := range ts {
+		t.owner = nil
+		if t.lost++; c.closed || (c.requeue >= 0 && t.lost > c.requeue) {
+			lost = append(lost, t)
+			continue
+		}
+		t.elem = c.queue.PushFront(t)
+	}
+	if len(lost) < len(ts) {
+		c.notify()
+	}
+	return lost
+}
+
+// 唤醒等任务的Poll,调用方需持有锁
+func (c *coordinator) notify() {
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
+
+// 在锁外给任务写入错误
+func (c *coordinator) fail(ts []*remoteTask, err error) {
+	for _, t := range ts {
+		t.fu.resolve(nil, err)
+	}
+}
+
+func (c *coordinator) Assign(ctx context.Context, name string, args []byte) Future[[]byte] {
+	t := &remoteTask{RemoteTask: RemoteTask{Name: name, Args: append([]byte(nil), args...)}}
+	//有结果后把任务从队列或者worker手上摘掉,被取消的任务就不会再派发
+	t.fu = newFuture[[]byte](func() { c.forget(t) })
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		t.fu.resolve(nil, ErrCoordinatorClosed)
+		return t.fu
+	}
+	t.ID = c.next
+	c.next++
+	t.elem = c.queue.PushBack(t)
+	c.notify()
+	c.mu.Unlock()
+
+	stop := context.AfterFunc(ctx, func() { t.fu.resolve(nil, ctx.Err()) })
+	t.fu.onDone(func() { stop() })
+	return t.fu
+}
+
+func (c *coordinator) forget(t *remoteTask) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t.elem != nil {
+		c.queue.Remove(t.elem)
+		t.elem = nil
+	}
+	if t.owner != nil {
+		delete(t.owner.inflight, t.ID)
+		t.owner = nil
+	}
+}
+
+func (c *coordinator) Workers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.workers)
+}
+
+func (c *coordinator) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.queue.Len()
+	for _, s := range c.workers {
+		n += len(s.inflight)
+	}
+	return n
+}
+
+func (c *coordinator) Addr() net.Addr {
+	return c.l.Addr()
+}
+
+func (c *coordinator) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.quit)
+	err := c.l.Close()
+	for conn := range c.conns {
+		conn.Close()
+	}
+	var lost []*remoteTask
+	for e := c.queue.Front(); e != nil; e = e.Next() {
+		t := e.Value.(*remoteTask)
+		t.elem = nil
+		lost = append(lost, t)
+	}
+	c.queue.Init()
+	c.mu.Unlock()
+
+	c.fail(lost, ErrCoordinatorClosed)
+	//连接断开后serve会把执行中的任务交给lose,closed时直接失败
+	c.wg.Wait()
+	return err
+}
+
+// 确认worker还在线并刷新心跳时间,调用方需持有锁
+func (s *session) touch() error {
+	if s.gone || s.id == 0 {
+		return errUnregistered
+	}
+	s.seen = time.Now()
+	return nil
+}
+
+// Register worker连上后先报告自己能处理的任务名,拿到心跳间隔
+func (s *session) Register(names []string, heartbeat *time.Duration) error {
+	if len(names) == 0 {
+		return ErrNoHandlers
+	}
+	c := s.c
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrCoordinatorClosed
+	}
+	if s.id != 0 || s.gone {
+		return errors.New("remote worker already registered")
+	}
+	s.names = make(map[string]bool, len(names))
+	for _, name := range names {
+		s.names[name] = true
+	}
+	c.seq++
+	s.id = c.seq
+	s.seen = time.Now()
+	c.workers[s.id] = s
+	*heartbeat = c.lease / 3
+	return nil
+}
+
+// Heartbeat worker定期调用,running为它手上的任务数
+func (s *session) Heartbeat(running int, ok *bool) error {
+	s.c.mu.Lock()
+	defer s.c.mu.Unlock()
+	if err := s.touch(); err != nil {
+		return err
+	}
+	*ok = true
+	return nil
+}
+
+// Poll 取最多max个任务,没有任务时最多等半个租约
+func (s *session) Poll(max int, tasks *[]RemoteTask) error {
+	c := s.c
+	timer := time.NewTimer(c.lease / 2)
+	defer timer.Stop()
+	for {
+		c.mu.Lock()
+		if err := s.touch(); err != nil {
+			c.mu.Unlock()
+			return err
+		}
+		for e := c.queue.Front(); e != nil && len(*tasks) < max; {
+			t := e.Value.(*remoteTask)
+			e = e.Next()
+			if !s.names[t.Name] {
+				continue
+			}
+			c.queue.Remove(t.elem)
+			t.elem = nil
+			t.owner = s
+			s.inflight[t.ID] = t
+			*tasks = append(*tasks, t.RemoteTask)
+		}
+		wake := c.wake
+		c.mu.Unlock()
+		if len(*tasks) > 0 || max <= 0 {
+			return nil
+		}
+
+		select {
+		case <-wake:
+		case <-timer.C:
+			return nil
+		case <-c.quit:
+			return ErrCoordinatorClosed
+		}
+	}
+}
+
+// Complete 回报任务结果,任务已经被取消或者交给了别的worker时忽略
+func (s *session) Complete(r RemoteResult, ok *bool) error {
+	c := s.c
+	c.mu.Lock()
+	if err := s.touch(); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	t := s.inflight[r.ID]
+	if t != nil {
+		delete(s.inflight, r.ID)
+		t.owner = nil
+	}
+	c.mu.Unlock()
+
+	if t != nil {
+		var err error
+		if r.Err != "" {
+			err = errors.New(r.Err)
+		}
+		t.fu.resolve(r.Value, err)
+		*ok = true
+	}
+	return nil
+}
+
+// ServeRemote 连上network/addr的Coordinator,作为远程worker在p上执行handlers能处理的任务,
+// 同时最多执行slots个,直到ctx取消或者和Coordinator断开才返回,返回前会等已经开始的任务结束,
+// 退出时没回报的任务由Coordinator交给别的worker
+func ServeRemote(ctx context.Context, network, addr string, p Pool, slots int, handlers map[string]RemoteHandler) error {
+	if len(handlers) == 0 {
+		return ErrNoHandlers
+	}
+	if slots <= 0 {
+		return ErrBadSize
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
+	if err != nil {
+		return err
+	}
+	client := rpc.NewClient(conn)
+	defer client.Close()
+
+	names := make([]string, 0, len(handlers))
+	for name := range handlers {
+		names = append(names, name)
+	}
+	var heartbeat time.Duration
+	if err := client.Call("Coordinator.Register", names, &heartbeat); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	//ctx结束时直接断开,正在等的Poll和心跳随之返回
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	s := sched(p)
+	sem := make(chan struct{}, slots)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		tk := time.NewTicker(heartbeat)
+		defer tk.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tk.C:
+				var ok bool
+				if err := client.Call("Coordinator.Heartbeat", len(sem), &ok); err != nil {
+					cancel(err)
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		//至少有一个空位才去取任务
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+		free := 1
+	fill:
+		for free < slots {
+			select {
+			case sem <- struct{}{}:
+				free++
+			default:
+				break fill
+			}
+		}
+
+		var tasks []RemoteTask
+		if err := client.Call("Coordinator.Poll", free, &tasks); err != nil {
+			cancel(err)
+			return context.Cause(ctx)
+		}
+		for i := len(tasks); i < free; i++ {
+			<-sem
+		}
+		for _, rt := range tasks {
+			wg.Add(1)
+			t := remoteJob(ctx, rt, handlers[rt.Name], func(r RemoteResult) {
+				wg.Done()
+				<-sem
+				//worker要退出了就不回报,让Coordinator交给别的worker
+				if ctx.Err() == nil {
+					client.Go("Coordinator.Complete", r, new(bool), nil)
+				}
+			})
+			if rc := s.submit(t); rc.Status == Rejected {
+				t.drop(rc.Err)
+			}
+		}
+	}
+}
+
+// 把远程任务包成线程池的任务,执行完,panic,被丢弃或者被拒绝时都调用一次finish,结果异步发出,不占着worker等网络
+func remoteJob(ctx context.Context, rt RemoteTask, h RemoteHandler, finish func(r RemoteResult)) *task {
+	var once sync.Once
+	end := func(r RemoteResult) { once.Do(func() { finish(r) }) }
+	t := newTask(ctx, func(ctx context.Context) {
+		defer catch(func(err error) { end(RemoteResult{ID: rt.ID, Err: err.Error()}) })
+		v, err := h(ctx, rt.Args)
+		r := RemoteResult{ID: rt.ID, Value: v}
+		if err != nil {
+			r.Err = err.Error()
+		}
+		end(r)
+	})
+	t.drop = func(err error) { end(RemoteResult{ID: rt.ID, Err: err.Error()}) }
+	return t
+}
@@ -0,0 +1,194 @@
+package Pool
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testLease = 300 * time.Millisecond
+
+// 在127.0.0.1的随机端口上起一个Coordinator
+func listenCoordinator(t *testing.T, opts ...CoordinatorOption) Coordinator {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCoordinator(l, append([]CoordinatorOption{WithLease(testLease)}, opts...)...)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// 起一个远程worker,返回让它退出的函数和ServeRemote的返回值
+func startWorker(t *testing.T, c Coordinator, p Pool, handlers map[string]RemoteHandler) (context.CancelFunc, <-chan error) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	n := c.Workers()
+	go func() { errc <- ServeRemote(ctx, "tcp", c.Addr().String(), p, 2, handlers) }()
+	waitFor(t, func() bool { return c.Workers() > n })
+	t.Cleanup(cancel)
+	return cancel, errc
+}
+
+func waitFor(t *testing.T, ok func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !ok(); time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+	}
+}
+
+// 执行到一半就卡住,直到worker退出
+func stuck(started chan<- struct{}) RemoteHandler {
+	return func(ctx context.Context, args []byte) ([]byte, error) {
+		started <- struct{}{}
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+}
+
+func echo(prefix string) RemoteHandler {
+	return func(ctx context.Context, args []byte) ([]byte, error) {
+		return append([]byte(prefix), args...), nil
+	}
+}
+
+func getRemote(t *testing.T, fu Future[[]byte]) ([]byte, error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	v, err := fu.GetCtx(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("remote task never finished")
+	}
+	return v, err
+}
+
+func TestRemoteTwoWorkers(t *testing.T) {
+	p := New(4)
+	defer p.ShutdownNow()
+	c := listenCoordinator(t)
+	startWorker(t, c, p, map[string]RemoteHandler{"echo": echo("a:")})
+	startWorker(t, c, p, map[string]RemoteHandler{"echo": echo("b:")})
+
+	fs := make([]Future[[]byte], 20)
+	for i := range fs {
+		fs[i] = c.Assign(context.Background(), "echo", []byte(strconv.Itoa(i)))
+	}
+	for i, fu := range fs {
+		v, err := getRemote(t, fu)
+		if s := string(v); err != nil || s != "a:"+strconv.Itoa(i) && s != "b:"+strconv.Itoa(i) {
+			t.Fatalf("task %d : %q , %v", i, v, err)
+		}
+	}
+	if n := c.Pending(); n != 0 {
+		t.Fatalf("pending %d after all tasks finished", n)
+	}
+}
+
+// worker执行到一半没了,任务交给另一个worker
+func TestRemoteRequeue(t *testing.T) {
+	p := New(4)
+	defer p.ShutdownNow()
+	c := listenCoordinator(t)
+	started := make(chan struct{}, 1)
+	kill, errc := startWorker(t, c, p, map[string]RemoteHandler{"job": stuck(started)})
+
+	fu := c.Assign(context.Background(), "job", []byte("x"))
+	<-started
+	startWorker(t, c, p, map[string]RemoteHandler{"job": echo("b:")})
+	kill()
+	if err := <-errc; err == nil {
+		t.Fatal("killed worker returned nil")
+	}
+	if v, err := getRemote(t, fu); err != nil || string(v) != "b:x" {
+		t.Fatalf("requeued task : %q , %v", v, err)
+	}
+	if n := c.Workers(); n != 1 {
+		t.Fatalf("workers %d , want 1", n)
+	}
+}
+
+// 重排次数用完后任务返回ErrWorkerLost
+func TestRemoteWorkerLost(t *testing.T) {
+	p := New(4)
+	defer p.ShutdownNow()
+	c := listenCoordinator(t, WithRequeue(1))
+	started := make(chan struct{}, 1)
+	fu := c.Assign(context.Background(), "job", nil)
+	for i := 0; i < 2; i++ {
+		kill, errc := startWorker(t, c, p, map[string]RemoteHandler{"job": stuck(started)})
+		<-started
+		kill()
+		<-errc
+		waitFor(t, func() bool { return c.Workers() == 0 })
+	}
+	if _, err := getRemote(t, fu); !errors.Is(err, ErrWorkerLost) {
+		t.Fatalf("err %v , want %v", err, ErrWorkerLost)
+	}
+	if n := c.Pending(); n != 0 {
+		t.Fatalf("pending %d after the task was lost", n)
+	}
+}
+
+// Close时排队和执行中的任务返回ErrCoordinatorClosed,worker随之退出
+func TestRemoteClose(t *testing.T) {
+	p := New(4)
+	defer p.ShutdownNow()
+	c := listenCoordinator(t)
+	started := make(chan struct{}, 1)
+	_, errc := startWorker(t, c, p, map[string]RemoteHandler{"job": stuck(started)})
+
+	running := c.Assign(context.Background(), "job", nil)
+	<-started
+	queued := c.Assign(context.Background(), "other", nil)
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, fu := range []Future[[]byte]{running, queued} {
+		if _, err := getRemote(t, fu); !errors.Is(err, ErrCoordinatorClosed) {
+			t.Fatalf("err %v , want %v", err, ErrCoordinatorClosed)
+		}
+	}
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("worker returned nil after close")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker still serving after close")
+	}
+	if _, err := getRemote(t, c.Assign(context.Background(), "job", nil)); !errors.Is(err, ErrCoordinatorClosed) {
+		t.Fatalf("assign after close : %v", err)
+	}
+	if c.Workers() != 0 {
+		t.Fatal("workers left after close")
+	}
+}
+
+// 连上以后一直不Register的连接过了租约也会被断开
+func TestRemoteReapUnregistered(t *testing.T) {
+	c := listenCoordinator(t)
+	conn, err := net.Dial("tcp", c.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	var buf [1]byte
+	if _, err := conn.Read(buf[:]); err == nil {
+		t.Fatal("read data from an unregistered connection")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("unregistered connection was never closed")
+	}
+	if d := time.Since(start); d < testLease {
+		t.Fatalf("closed after %v , before the lease", d)
+	}
+}
@@ -0,0 +1,451 @@
+package Pool
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 资源池后台巡检的默认间隔,没有设置MaxLifetime和MaxIdleTime时使用
+const DefaultResourceTick = time.Second
+
+// ResourceConfig 资源池的配置
+type ResourceConfig[T any] struct {
+	New         func(ctx context.Context) (T, error) //创建资源,必须设置
+	Close       func(v T)                            //销毁资源,可以为nil
+	Check       func(ctx context.Context, v T) error //借出前的健康检查,返回错误时销毁后换一个,可以为nil
+	MinIdle     int                                  //后台保持的最少空闲资源数
+	MaxOpen     int                                  //最多同时存在的资源数,不大于0表示不限
+	MaxLifetime time.Duration                        //资源创建后最长使用时间,0表示不限
+	MaxIdleTime time.Duration                        //资源空闲超过这个时间就销毁,但不低于MinIdle,0表示不限
+}
+
+// ResourceStats 资源池运行状态快照
+type ResourceStats struct {
+	Open        int       //现有的资源数,包括正在创建的
+	Idle        int       //空闲的资源数
+	InUse       int       //借出去的资源数
+	Waiting     int       //排队等资源的调用方数
+	Acquired    int64     //借出的次数
+	Misses      int64     //借的时候没有空闲资源的次数
+	Created     int64     //创建过的资源数
+	Destroyed   int64     //销毁过的资源数
+	CheckFailed int64     //健康检查失败的次数
+	Wait        Histogram //排队等资源的耗时
+}
+
+// ResourcePool 可复用资源的池子,比如数据库连接,客户端,缓冲区,关闭语义与Pool一致
+type ResourcePool[T any] interface {
+	Acquire(ctx context.Context) (Resource[T], error) //借一个资源,没有空闲且已经到MaxOpen时排队等,ctx取消时返回ctx的错误
+	Stats() ResourceStats
+	Shutdown(ctx context.Context) error //不再借出,销毁空闲资源,等借出去的都还回来后销毁,ctx到期时返回ctx的错误
+	back() *resourcePool[T]
+}
+
+// Resource 借出的资源,用完后调用Release或者Discard之一,重复调用无效
+type Resource[T any] interface {
+	Value() T
+	Release() //还回池子
+	Discard() //资源已经坏了,直接销毁
+}
+
+// 资源池实体
+type resourcePool[T any] struct {
+	cfg ResourceConfig[T]
+
+	mu      sync.Mutex
+	idle    []*resource[T] //空闲的资源,后还回来的在后面,优先借出
+	open    int
+	waiters *list.List //排队的调用方,元素为chan *resource[T]
+	closed  bool
+	quit    chan struct{} //关闭时关闭
+	drained chan struct{} //关闭后资源全部销毁时关闭
+
+	acquired    int64
+	misses      int64
+	created     int64
+	destroyed   int64
+	checkFailed int64
+	wait        histogram
+}
+
+// 池子里的一个资源
+type resource[T any] struct {
+	v       T
+	created time.Time
+	used    time.Time //最近一次还回来的时间
+}
+
+// 一次借出,每次借出一个新的句柄,过期的句柄重复归还不会影响别人
+type borrowed[T any] struct {
+	p    *resourcePool[T]
+	r    *resource[T]
+	done atomic.Bool
+}
+
+// NewResourcePool 创建资源池,后台按MinIdle补充空闲资源并清理过期的
+func NewResourcePool[T any](cfg ResourceConfig[T]) ResourcePool[T] {
+	if cfg.New == nil {
+		fmt.Println(" resource pool needs a New func ! please again ")
+		return nil
+	}
+	if cfg.MinIdle < 0 || cfg.MaxOpen > 0 && cfg.MinIdle > cfg.MaxOpen {
+		fmt.Println(" wrong resource pool MinIdle ! please again ")
+		return nil
+	}
+	p := &resourcePool[T]{
+		cfg:     cfg,
+		waiters: list.New(),
+		quit:    make(chan struct{}),
+		drained: make(chan struct{}),
+	}
+	go p.maintain()
+	return p
+}
+
+// 巡检间隔,取MaxLifetime和MaxIdleTime中较小的一半
+func (p *resourcePool[T]) tick() time.Duration {
+	d := DefaultResourceTick
+	for _, v := range []time.Duration{p.cfg.MaxLifetime, p.cfg.MaxIdleTime} {
+		if v > 0 && v/2 < d {
+			d = v / 2
+		}
+	}
+	if d < minScaleTick {
+		d = minScaleTick
+	}
+	return d
+}
+
+// 后台巡检,先补足MinIdle,之后定期清理过期资源再补足
+func (p *resourcePool[T]) maintain() {
+	tk := time.NewTicker(p.tick())
+	defer tk.Stop()
+	for {
+		p.evict()
+		p.fill()
+		select {
+		case <-p.quit:
+			return
+		case <-tk.C:
+		}
+	}
+}
+
+// 清理过期的空闲资源,只因为空闲太久的不低于MinIdle
+func (p *resourcePool[T]) evict() {
+	now := time.Now()
+	var dead []*resource[T]
+	p.mu.Lock()
+	keep := p.idle[:0]
+	for i, r := range p.idle {
+		//从旧到新,空闲太久的只销毁到剩MinIdle个
+		spare := len(keep)+len(p.idle)-i-1 >= p.cfg.MinIdle
+		if p.old(r, now) || spare && p.stale(r, now) {
+			dead = append(dead, r)
+			continue
+		}
+		keep = append(keep, r)
+	}
+	clear(p.idle[len(keep):])
+	p.idle = keep
+	for range dead {
+		p.free()
+	}
+	p.mu.Unlock()
+	for _, r := range dead {
+		p.destroy(r)
+	}
+}
+
+// 补足MinIdle,创建失败就等下一次巡检
+func (p *resourcePool[T]) fill() {
+	for {
+		p.mu.Lock()
+		if p.closed || len(p.idle) >= p.cfg.MinIdle || p.full() {
+			p.mu.Unlock()
+			return
+		}
+		p.open++
+		p.mu.Unlock()
+
+		r, err := p.create(context.Background())
+		if err != nil {
+			p.mu.Lock()
+			p.free()
+			p.mu.Unlock()
+			return
+		}
+		p.put(r)
+	}
+}
+
+func (p *resourcePool[T]) Acquire(ctx context.Context) (Resource[T], error) {
+	missed := false
+	for {
+		r, err := p.take(ctx, &missed)
+		if err != nil {
+			return nil, err
+		}
+		if r == nil {
+			//拿到了创建名额
+			if r, err = p.create(ctx); err != nil {
+				p.mu.Lock()
+				p.free()
+				p.mu.Unlock()
+				return nil, err
+			}
+		} else if p.cfg.Check != nil {
+			if err := p.cfg.Check(ctx, r.v); err != nil {
+				atomic.AddInt64(&p.checkFailed, 1)
+				p.drop(r)
+				continue
+			}
+		}
+		atomic.AddInt64(&p.acquired, 1)
+		return &borrowed[T]{p: p, r: r}, nil
+	}
+}
+
+// 拿一个空闲资源,没有空闲但还能创建时返回nil表示由调用方创建,否则排队等别人还回来
+func (p *resourcePool[T]) take(ctx context.Context, missed *bool) (*resource[T], error) {
+	p.mu.Lock()
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if err := ctx.Err(); err != nil {
+			p.mu.Unlock()
+			return nil, err
+		}
+		now := time.Now()
+		for len(p.idle) > 0 {
+			r := p.idle[len(p.idle)-1]
+			p.idle[len(p.idle)-1] = nil
+			p.idle = p.idle[:len(p.idle)-1]
+			if p.old(r, now) || p.stale(r, now) {
+				p.open--
+				p.mu.Unlock()
+				p.destroy(r)
+				p.mu.Lock()
+				continue
+			}
+			p.mu.Unlock()
+			return r, nil
+		}
+		if !*missed {
+			*missed = true
+			atomic.AddInt64(&p.misses, 1)
+		}
+		if !p.full() {
+			p.open++
+			p.mu.Unlock()
+			return nil, nil
+		}
+
+		//排队,别人还回来的资源直接交到手上,收到nil表示腾出的创建名额留给了自己,
+		//名额已经计入open,后来的调用方抢不走,排队的先来先得
+		ch := make(chan *resource[T], 1)
+		elem := p.waiters.PushBack(ch)
+		p.mu.Unlock()
+		start := time.Now()
+		select {
+		case r := <-ch:
+			p.wait.observe(time.Since(start))
+			if r != nil {
+				return r, nil
+			}
+			p.mu.Lock()
+			if p.closed {
+				p.open--
+				p.settle()
+				p.mu.Unlock()
+				return nil, ErrPoolClosed
+			}
+			p.mu.Unlock()
+			return nil, nil
+		case <-ctx.Done():
+			p.wait.observe(time.Since(start))
+			p.mu.Lock()
+			p.waiters.Remove(elem)
+			p.mu.Unlock()
+			//出队前可能刚好有人交过来,转给下一个
+			select {
+			case r := <-ch:
+				if r != nil {
+					p.put(r)
+				} else {
+					p.mu.Lock()
+					p.free()
+					p.mu.Unlock()
+				}
+			default:
+			}
+			return nil, ctx.Err()
+		case <-p.quit:
+			p.mu.Lock()
+			p.waiters.Remove(elem)
+			p.mu.Unlock()
+			select {
+			case r := <-ch:
+				if r != nil {
+					p.drop(r)
+				} else {
+					p.mu.Lock()
+					p.open--
+					p.settle()
+					p.mu.Unlock()
+				}
+			default:
+			}
+			return nil, ErrPoolClosed
+		}
+	}
+}
+
+func (p *resourcePool[T]) create(ctx context.Context) (*resource[T], error) {
+	v, err := p.cfg.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&p.created, 1)
+	now := time.Now()
+	return &resource[T]{v: v, created: now, used: now}, nil
+}
+
+// 资源回到池子,有人排队就直接交给队首,关闭后或者过期了就销毁
+func (p *resourcePool[T]) put(r *resource[T]) {
+	now := time.Now()
+	p.mu.Lock()
+	if p.closed || p.old(r, now) {
+		p.mu.Unlock()
+		p.drop(r)
+		return
+	}
+	r.used = now
+	if e := p.waiters.Front(); e != nil {
+		p.waiters.Remove(e)
+		e.Value.(chan *resource[T]) <- r
+		p.mu.Unlock()
+		return
+	}
+	p.idle = append(p.idle, r)
+	p.mu.Unlock()
+}
+
+// 销毁一个计入open的资源,腾出的名额交给排队的
+func (p *resourcePool[T]) drop(r *resource[T]) {
+	p.mu.Lock()
+	p.free()
+	p.mu.Unlock()
+	p.destroy(r)
+}
+
+// 只销毁资源,不动open
+func (p *resourcePool[T]) destroy(r *resource[T]) {
+	if p.cfg.Close != nil {
+		p.cfg.Close(r.v)
+	}
+	atomic.AddInt64(&p.destroyed, 1)
+	p.mu.Lock()
+	p.settle()
+	p.mu.Unlock()
+}
+
+// 关闭后资源全部销毁时通知Shutdown,调用方需持有锁
+func (p *resourcePool[T]) settle() {
+	if p.closed && p.open == 0 && !isClosed(p.drained) {
+		close(p.drained)
+	}
+}
+
+// 腾出了一个创建名额,有人排队就直接转给队首,名额仍然计入open,由它自己去创建,
+// 没人排队才从open里减掉,调用方需持有锁
+func (p *resourcePool[T]) free() {
+	if e := p.waiters.Front(); e != nil {
+		p.waiters.Remove(e)
+		e.Value.(chan *resource[T]) <- nil
+		return
+	}
+	p.open--
+}
+
+// 是否到了MaxOpen,调用方需持有锁
+func (p *resourcePool[T]) full() bool {
+	return p.cfg.MaxOpen > 0 && p.open >= p.cfg.MaxOpen
+}
+
+// 是否超过了MaxLifetime
+func (p *resourcePool[T]) old(r *resource[T], now time.Time) bool {
+	return p.cfg.MaxLifetime > 0 && now.Sub(r.created) >= p.cfg.MaxLifetime
+}
+
+// 是否空闲超过了MaxIdleTime
+func (p *resourcePool[T]) stale(r *resource[T], now time.Time) bool {
+	return p.cfg.MaxIdleTime > 0 && now.Sub(r.used) >= p.cfg.MaxIdleTime
+}
+
+func (p *resourcePool[T]) Stats() ResourceStats {
+	p.mu.Lock()
+	s := ResourceStats{
+		Open:    p.open,
+		Idle:    len(p.idle),
+		InUse:   p.open - len(p.idle),
+		Waiting: p.waiters.Len(),
+	}
+	p.mu.Unlock()
+	s.Acquired = atomic.LoadInt64(&p.acquired)
+	s.Misses = atomic.LoadInt64(&p.misses)
+	s.Created = atomic.LoadInt64(&p.created)
+	s.Destroyed = atomic.LoadInt64(&p.destroyed)
+	s.CheckFailed = atomic.LoadInt64(&p.checkFailed)
+	s.Wait = p.wait.snapshot()
+	return s
+}
+
+func (p *resourcePool[T]) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.quit)
+	}
+	idle := p.idle
+	p.idle = nil
+	p.open -= len(idle)
+	p.settle()
+	p.mu.Unlock()
+	for _, r := range idle {
+		p.destroy(r)
+	}
+
+	select {
+	case <-p.drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *resourcePool[T]) back() *resourcePool[T] {
+	return p
+}
+
+func (b *borrowed[T]) Value() T {
+	return b.r.v
+}
+
+func (b *borrowed[T]) Release() {
+	if b.done.CompareAndSwap(false, true) {
+		b.p.put(b.r)
+	}
+}
+
+func (b *borrowed[T]) Discard() {
+	if b.done.CompareAndSwap(false, true) {
+		b.p.drop(b.r)
+	}
+}
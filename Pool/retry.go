@@ -0,0 +1,182 @@
+package Pool
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// 重试策略的默认值
+const (
+	DefaultMaxAttempts = 3
+	DefaultBackoff     = 100 * time.Millisecond
+)
+
+// Jitter 重试等待时间的抖动方式
+type Jitter int
+
+const (
+	NoJitter           Jitter = iota //不抖动,按指数退避
+	FullJitter                       //在[0,指数退避时间]里均匀取
+	DecorrelatedJitter               //在[Initial,上次等待*3]里均匀取
+)
+
+// RetryPolicy 任务返回错误时的重试策略
+type RetryPolicy struct {
+	MaxAttempts int                  //最多执行次数,包括第一次,不大于0时用DefaultMaxAttempts
+	Initial     time.Duration        //第一次重试前的等待,不大于0时用DefaultBackoff
+	Max         time.Duration        //等待时间的上限,不大于0表示不设上限
+	Multiplier  float64              //每次重试等待时间的倍数,小于1时按2算
+	Jitter      Jitter               //抖动方式
+	Retryable   func(err error) bool //返回false的错误不再重试,nil表示所有错误都重试
+	OnAttempt   func(a Attempt)      //每次执行结束后回调,在执行任务的worker上调用
+}
+
+// Attempt 一次执行的结果
+type Attempt struct {
+	N     int           //第几次执行,从1开始
+	Err   error         //这次执行的错误,nil表示成功
+	Retry bool          //是否还会重试
+	Delay time.Duration //下次重试前的等待
+}
+
+func (r *RetryPolicy) fix() {
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = DefaultMaxAttempts
+	}
+	if r.Initial <= 0 {
+		r.Initial = DefaultBackoff
+	}
+	if r.Multiplier < 1 {
+		r.Multiplier = 2
+	}
+}
+
+// 第n次执行失败后的等待时间,prev为上一次的等待
+func (r *RetryPolicy) backoff(n int, prev time.Duration) time.Duration {
+	var d time.Duration
+	switch r.Jitter {
+	case DecorrelatedJitter:
+		//上界为prev*3,不超过Initial时用Initial*3,乘3可能溢出,先封顶
+		base := prev
+		if base <= r.Initial/3 {
+			base = r.Initial
+		}
+		hi := time.Duration(math.MaxInt64)
+		if base < math.MaxInt64/3 {
+			hi = base * 3
+		}
+		d = r.Initial + time.Duration(rand.Int63n(int64(hi-r.Initial)+1))
+	default:
+		f := float64(r.Initial) * math.Pow(r.Multiplier, float64(n-1))
+		switch {
+		case r.Max > 0 && f > float64(r.Max):
+			d = r.Max
+		case !(f < math.MaxInt64):
+			//次数多了会溢出成MaxInt64甚至Inf,封顶并给下面的+1留出余量
+			d = math.MaxInt64 - 1
+		default:
+			d = time.Duration(f)
+		}
+		if r.Jitter == FullJitter {
+			d = time.Duration(rand.Int63n(int64(d) + 1))
+		}
+	}
+	if r.Max > 0 && d > r.Max {
+		d = r.Max
+	}
+	return d
+}
+
+// Retry 把返回错误的任务提交到线程池,失败时按policy重试,返回最后一次执行的结果
+func Retry(ctx context.Context, p Pool, policy RetryPolicy, f func(ctx context.Context) error) Future[struct{}] {
+	return SubmitRetry(ctx, p, policy, func(ctx context.Context) (struct{}, error) { return struct{}{}, f(ctx) })
+}
+
+// SubmitRetry 同SubmitCtx,失败时按policy重试,等待期间不占worker,到时间后重新进入线程池的队列,
+// 已经接收的重试不受队列容量限制,Shutdown也会等它们执行完,panic不重试
+func SubmitRetry[T any](ctx context.Context, p Pool, policy RetryPolicy, f func(ctx context.Context) (T, error)) Future[T] {
+	fu, _ := submitRetry(ctx, p, policy, f)
+	return fu
+}
+
+// 同SubmitRetry,另外返回第一次提交的回执,被拒绝时Future也已经有了结果
+func submitRetry[T any](ctx context.Context, p Pool, policy RetryPolicy, f func(ctx context.Context) (T, error)) (*future[T], Receipt) {
+	policy.fix()
+	ctx, cancel := context.WithCancel(ctx)
+	r := &retry[T]{p: sched(p), ctx: ctx, policy: policy, f: f, fu: newFuture[T](cancel)}
+	return r.fu, r.submit(false)
+}
+
+// 一个带重试的任务,同一时刻只有一次执行,状态不用加锁
+type retry[T any] struct {
+	p      scheduler
+	ctx    context.Context
+	policy RetryPolicy
+	f      func(ctx context.Context) (T, error)
+	fu     *future[T]
+	n      int           //已经执行的次数
+	delay  time.Duration //上一次的等待
+}
+
+// 提交一次执行,被拒绝时直接给出结果
+func (r *retry[T]) submit(follow bool) Receipt {
+	var zero T
+	t := newTask(r.ctx, nil)
+	t.follow = follow
+	t.fn = func(ctx context.Context) {
+		defer catch(func(err error) { r.fu.resolve(zero, err) })
+		v, err := r.f(ctx)
+		r.n++
+		a := Attempt{N: r.n, Err: err}
+		if err != nil && r.n < r.policy.MaxAttempts && r.ctx.Err() == nil &&
+			(r.policy.Retryable == nil || r.policy.Retryable(err)) {
+			a.Retry = true
+			a.Delay = r.policy.backoff(r.n, r.delay)
+			r.delay = a.Delay
+		}
+		if r.policy.OnAttempt != nil {
+			r.policy.OnAttempt(a)
+		}
+		if a.Retry {
+			r.wait(a.Delay)
+			return
+		}
+		t.err = err
+		r.fu.resolve(v, err)
+	}
+	t.drop = func(err error) { r.fu.resolve(zero, err) }
+	rc := r.p.submit(t)
+	if rc.Status == Rejected {
+		r.fu.resolve(zero, rc.Err)
+	}
+	return rc
+}
+
+// 等待d后重新提交,等待期间占住线程池的pending和提交方任务的scope,ctx取消时提前结束
+func (r *retry[T]) wait(d time.Duration) {
+	r.p.hold()
+	s := scopeOf(r.ctx).acquire()
+	var fired int32
+	//到时和ctx取消只有先到的生效
+	fire := func() bool { return atomic.CompareAndSwapInt32(&fired, 0, 1) }
+	stop := context.AfterFunc(r.ctx, func() {
+		if fire() {
+			var zero T
+			r.fu.resolve(zero, r.ctx.Err())
+			r.p.release()
+			s.release()
+		}
+	})
+	time.AfterFunc(d, func() {
+		if !fire() {
+			return
+		}
+		stop()
+		defer r.p.release()
+		defer s.release()
+		r.submit(true)
+	})
+}
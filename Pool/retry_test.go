@@ -0,0 +1,73 @@
+package Pool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 队列空了以后还有重试在等待时,Shutdown要等到重试执行完,不能让worker先退出
+func TestRetryShutdown(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"default": nil,
+		"steal":   {WithWorkStealing()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := New(2, opts...)
+			var n int32
+			fu := Retry(context.Background(), p, RetryPolicy{MaxAttempts: 3, Initial: 20 * time.Millisecond}, func(context.Context) error {
+				if atomic.AddInt32(&n, 1) < 3 {
+					return errors.New("flaky")
+				}
+				return nil
+			})
+			//等第一次执行失败,进入重试等待
+			for atomic.LoadInt32(&n) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			if err := p.Shutdown(ctx); err != nil {
+				t.Fatalf("shutdown: %v", err)
+			}
+			if _, err := fu.GetCtx(ctx); err != nil || atomic.LoadInt32(&n) != 3 {
+				t.Fatalf("attempts %d , err %v", n, err)
+			}
+			if r := p.Assign(func() {}); r[0].Status != Rejected {
+				t.Fatal("pool accepted a task after shutdown")
+			}
+		})
+	}
+}
+
+// ShutdownNow不等重试,等待中的重试拿到拒绝
+func TestRetryShutdownNow(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"default": nil,
+		"steal":   {WithWorkStealing()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := New(2, opts...)
+			started := make(chan struct{}, 1)
+			fu := Retry(context.Background(), p, RetryPolicy{MaxAttempts: 3, Initial: 20 * time.Millisecond}, func(context.Context) error {
+				select {
+				case started <- struct{}{}:
+				default:
+				}
+				return errors.New("flaky")
+			})
+			<-started
+			p.ShutdownNow()
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+			if _, err := fu.GetCtx(ctx); !errors.Is(err, ErrPoolClosed) {
+				t.Fatalf("err %v , want %v", err, ErrPoolClosed)
+			}
+			if err := p.Shutdown(ctx); err != nil {
+				t.Fatalf("shutdown: %v", err)
+			}
+		})
+	}
+}
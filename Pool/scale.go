@@ -0,0 +1,118 @@
+package Pool
+
+import "time"
+
+// 自动伸缩的默认参数
+const (
+	DefaultKeepAlive = time.Minute
+	minScaleTick     = 10 * time.Millisecond
+)
+
+// Autoscale 自动伸缩的配置
+type Autoscale struct {
+	Min        int           //最少worker数
+	Max        int           //最多worker数
+	KeepAlive  time.Duration //空闲超过这个时间的worker会退休,但总数不低于Min
+	QueueDepth int           //队列长度超过它且排队任务多于空闲worker时扩容,0表示不看队列长度
+	QueueWait  time.Duration //队首任务等待超过它且排队任务多于空闲worker时扩容,0表示不看等待时间
+}
+
+// 修正不合理的配置
+func (a *Autoscale) fix() {
+	if a.Min <= 0 {
+		a.Min = 1
+	}
+	if a.Max < a.Min {
+		a.Max = a.Min
+	}
+	if a.KeepAlive <= 0 {
+		a.KeepAlive = DefaultKeepAlive
+	}
+}
+
+func (a *Autoscale) clamp(n int) int {
+	if n < a.Min {
+		return a.Min
+	}
+	if n > a.Max {
+		return a.Max
+	}
+	return n
+}
+
+// 巡检间隔,取KeepAlive和QueueWait中较小的一半
+func (a *Autoscale) tick() time.Duration {
+	d := a.KeepAlive
+	if a.QueueWait > 0 && a.QueueWait < d {
+		d = a.QueueWait
+	}
+	d /= 2
+	if d < minScaleTick {
+		d = minScaleTick
+	}
+	return d
+}
+
+// Resize 调整worker数量,多出来的worker执行完手上的任务后退出
+func (p *pool) Resize(n int) error {
+	if n <= 0 {
+		return ErrBadSize
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrPoolClosed
+	}
+	if p.scale != nil {
+		n = p.scale.clamp(n)
+	}
+	p.cap = n
+	for len(p.workers) < p.cap {
+		p.spawn()
+	}
+	//唤醒空闲的worker,多余的会自己退出
+	p.notEmpty.Broadcast()
+	return nil
+}
+
+// 排队的任务比空闲worker多且未到上限时扩容一个,调用方需持有锁
+func (p *pool) grow() {
+	if p.closed || p.queue.len() <= len(p.workers)-p.busy || p.cap >= p.scale.Max {
+		return
+	}
+	p.cap++
+	p.spawn()
+}
+
+// 自动伸缩的巡检协程,按队首等待时间扩容,按空闲时间裁撤,
+// 裁撤只降低目标数量,由被唤醒的空闲worker自己退出
+func (p *pool) autoscale() {
+	ticker := time.NewTicker(p.scale.tick())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-ticker.C:
+		}
+		p.mu.Lock()
+		now := time.Now()
+		if t := p.queue.peek(); t != nil && p.scale.QueueWait > 0 && now.Sub(t.at) > p.scale.QueueWait {
+			p.grow()
+		}
+		retired := false
+		for w := range p.workers {
+			if p.cap <= p.scale.Min {
+				break
+			}
+			if !w.isAssign && now.Sub(w.idleSince) > p.scale.KeepAlive {
+				p.cap--
+				retired = true
+			}
+		}
+		if retired {
+			p.notEmpty.Broadcast()
+		}
+		p.mu.Unlock()
+	}
+}
@@ -0,0 +1,147 @@
+package Pool
+
+import (
+	"expvar"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBuckets 耗时直方图的分桶上界
+var DefaultBuckets = []time.Duration{
+	10 * time.Microsecond,
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// Histogram 耗时直方图快照,Counts比Bounds多一格,最后一格记录超过所有上界的
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []int64
+	Count  int64
+	Sum    time.Duration
+}
+
+// Mean 平均耗时
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// histogram 并发安全的耗时直方图,计数全走原子操作
+type histogram struct {
+	counts [8]int64 //与DefaultBuckets对应,多一格溢出桶
+	count  int64
+	sum    int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(DefaultBuckets) && d > DefaultBuckets[i] {
+		i++
+	}
+	atomic.AddInt64(&h.counts[i], 1)
+	atomic.AddInt64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+func (h *histogram) snapshot() Histogram {
+	s := Histogram{
+		Bounds: DefaultBuckets,
+		Counts: make([]int64, len(h.counts)),
+		Count:  atomic.LoadInt64(&h.count),
+		Sum:    time.Duration(atomic.LoadInt64(&h.sum)),
+	}
+	for i := range h.counts {
+		s.Counts[i] = atomic.LoadInt64(&h.counts[i])
+	}
+	return s
+}
+
+// Stats 线程池运行状态快照
+type Stats struct {
+	Submitted        int64                  //提交过的任务数,包括被拒绝的
+	Rejected         int64                  //提交时被拒绝的任务数
+	Dropped          int64                  //接收后没执行就被丢弃的任务数,比如ctx取消,被DropOldest挤掉,ShutdownNow
+	Completed        int64                  //执行完的任务数,包括失败的
+	Failed           int64                  //执行失败的任务数,即panic或者Future返回了错误
+	Panicked         int64                  //panic的任务数
+	TimedOut         int64                  //执行超时被取消的任务数
+	Stragglers       int64                  //超时后仍不返回被放弃的任务数
+	Queued           int                    //当前排队的任务数
+	Busy             int                    //正在执行任务的worker数
+	Idle             int                    //空闲的worker数
+	Timers           int                    //还没到期的定时任务数
+	QueuedByPriority map[int]int            //每个优先级排队的任务数,开启WithPriority时才有
+	BudgetUsed       int64                  //带权重任务占用的预算,开启WithBudget时才有
+	Tenants          map[string]TenantStats //有任务排队或者在执行的租户的运行状态,开启WithTenants时才有
+	QueueWait        Histogram              //任务从入队到开始执行的耗时
+	Exec             Histogram              //任务执行的耗时
+}
+
+// 线程池的累计计数
+type counters struct {
+	submitted  int64
+	rejected   int64
+	dropped    int64
+	completed  int64
+	failed     int64
+	panicked   int64
+	timedOut   int64
+	stragglers int64
+	wait       histogram
+	exec       histogram
+}
+
+// Stats 返回线程池当前的运行状态
+func (p *pool) Stats() Stats {
+	p.mu.Lock()
+	s := Stats{
+		Queued: p.queue.len(),
+		Busy:   p.busy,
+		Idle:   len(p.workers) - p.busy,
+	}
+	switch q := p.queue.(type) {
+	case *prioq:
+		s.QueuedByPriority = q.depths()
+	case *fairq:
+		s.Tenants = q.stats()
+	}
+	if p.budget != nil {
+		s.BudgetUsed = p.budget.inUse()
+	}
+	p.mu.Unlock()
+
+	s.Timers = p.timers.len()
+	p.stats.fill(&s)
+	return s
+}
+
+// 把Stats发布到expvar,名字已被占用时放弃发布
+func publish(name string, stats func() Stats) {
+	if expvar.Get(name) != nil {
+		fmt.Printf("expvar name %q already in use , pool stats not published\n", name)
+		return
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} { return stats() }))
+}
+
+// 填充累计计数部分
+func (c *counters) fill(s *Stats) {
+	s.Submitted = atomic.LoadInt64(&c.submitted)
+	s.Rejected = atomic.LoadInt64(&c.rejected)
+	s.Dropped = atomic.LoadInt64(&c.dropped)
+	s.Completed = atomic.LoadInt64(&c.completed)
+	s.Failed = atomic.LoadInt64(&c.failed)
+	s.Panicked = atomic.LoadInt64(&c.panicked)
+	s.TimedOut = atomic.LoadInt64(&c.timedOut)
+	s.Stragglers = atomic.LoadInt64(&c.stragglers)
+	s.QueueWait = c.wait.snapshot()
+	s.Exec = c.exec.snapshot()
+}
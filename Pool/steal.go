@@ -0,0 +1,601 @@
+package Pool
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 工作窃取调度下任务的认领状态
+const (
+	waiting int32 = iota //还在某个队列里
+	claimed              //已被worker取走或者被丢弃
+)
+
+// workerKey 执行中任务的ctx里存放当前worker的键,子任务靠它找到本地队列
+type workerKey struct{}
+
+// deque 带锁的双端队列,主人从队尾存取,别的worker从队首偷
+type deque struct {
+	mu   sync.Mutex
+	q    fifo
+	dead bool //worker退休后不再接收任务
+}
+
+func (d *deque) push(t *task) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dead {
+		return false
+	}
+	d.q.push(t)
+	return true
+}
+
+// 放回队首,worker退休了返回false
+func (d *deque) pushFront(t *task) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.dead {
+		return false
+	}
+	d.q.pushFront(t)
+	return true
+}
+
+func (d *deque) popBack() *task {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.q.popBack()
+}
+
+func (d *deque) popFront() *task {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.q.pop()
+}
+
+func (d *deque) remove(t *task) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.q.remove(t)
+}
+
+// 标记退休并取出剩下的任务
+func (d *deque) close() []*task {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dead = true
+	ts := make([]*task, 0, d.q.len())
+	for t := d.q.pop(); t != nil; t = d.q.pop() {
+		ts = append(ts, t)
+	}
+	return ts
+}
+
+type stealWorker struct {
+	worker
+	pool  *stealPool
+	local deque
+	quit  atomic.Bool //被Resize裁撤,执行完手上的任务后退出
+}
+
+// 工作窃取实现,每个worker一个本地队列,外部提交的任务进全局队列,
+// 取任务时不碰全局锁,只有挂起和唤醒worker时才加锁
+type stealPool struct {
+	core
+	gate     sync.RWMutex                   //提交时读锁,关闭时写锁,保证关闭后不会再有任务入队
+	mu       sync.Mutex                     //保护worker列表的修改,挂起唤醒和idle
+	notEmpty *sync.Cond                     //有新任务的信号,挂起的worker在上面等待
+	notFull  *sync.Cond                     //队列腾出空位的信号,Block策略下使用
+	ws       atomic.Pointer[[]*stealWorker] //写时复制的worker列表,偷任务时不加锁遍历
+	global   deque                          //外部提交的任务
+	cap      int                            //worker的目标数量
+	closed   atomic.Bool                    //是否已经关闭
+	size     int64                          //所有队列里等待执行的任务数,原子操作
+	busy     int64                          //正在执行任务的worker数,原子操作
+	parked   int32                          //挂起等待任务的worker数,原子操作
+	blocked  int32                          //Block策略下等待空位的调用方数,原子操作
+	pending  int64                          //已接收但还没执行完的任务数,原子操作
+	idle     chan struct{}                  //pending归零时关闭,由mu保护
+	seq      uint32                         //偷任务的起点,轮转着偷
+}
+
+func newStealPool(cap int, c config) *stealPool {
+	p := &stealPool{cap: cap, idle: make(chan struct{})}
+	p.init(c)
+	close(p.idle)
+	p.notEmpty = sync.NewCond(&p.mu)
+	p.notFull = sync.NewCond(&p.mu)
+	ws := make([]*stealWorker, 0, cap)
+	p.ws.Store(&ws)
+	if p.expvar != "" {
+		publish(p.expvar, p.Stats)
+	}
+	p.mu.Lock()
+	for i := 0; i < cap; i++ {
+		p.spawn()
+	}
+	p.mu.Unlock()
+	return p
+}
+
+// Assign 外部提交的任务进全局队列
+func (p *stealPool) Assign(fs ...TaskFunc) []Receipt {
+	return assign(p.submit, context.Background(), 1, wrap(fs))
+}
+
+// AssignCtx 在任务里用任务自己的ctx(或者由它派生的ctx)提交时,子任务进当前worker的本地队列,
+// 父任务先返回也不影响还在排队的子任务
+func (p *stealPool) AssignCtx(ctx context.Context, fs ...CtxTaskFunc) []Receipt {
+	return assign(p.submit, ctx, 1, fs)
+}
+
+// AssignN 同AssignCtx,每个任务派发时从限流器拿n个令牌
+func (p *stealPool) AssignN(ctx context.Context, n int, fs ...CtxTaskFunc) []Receipt {
+	return assign(p.submit, ctx, n, fs)
+}
+
+// AssignWith 提交单个任务,opts对这个任务单独生效
+func (p *stealPool) AssignWith(ctx context.Context, f CtxTaskFunc, opts ...TaskOption) Receipt {
+	return p.submit(newTask(ctx, f).with(opts))
+}
+
+// AssignKeyed 同一个key的任务按提交顺序逐个执行,后续任务进全局队列
+func (p *stealPool) AssignKeyed(key string, fs ...TaskFunc) []Receipt {
+	return p.keys.assign(p.submit, key, wrap(fs))
+}
+
+func (p *stealPool) submit(t *task) (r Receipt) {
+	if err := p.admit(t); err != nil {
+		return p.reject(err)
+	}
+	if err := p.weigh(t); err != nil {
+		return p.reject(err)
+	}
+	defer func() {
+		if r.Status == Rejected {
+			p.unweigh(t)
+		}
+	}()
+	status := Queued
+	for !p.closed.Load() && !t.follow && t.ctx.Err() == nil && atomic.LoadInt64(&p.size) >= int64(p.qcap) {
+		switch p.policy {
+		case Reject:
+			return p.reject(ErrQueueFull)
+		case CallerRuns:
+			return p.callerRun(t)
+		case DropOldest:
+			if old := p.evict(); old != nil {
+				p.discard(old, ErrDropped)
+				status = Displaced
+			} else {
+				//最老的任务正被别人取走,让一下再看
+				runtime.Gosched()
+			}
+		default:
+			p.waitSpace(t.ctx)
+		}
+	}
+
+	p.gate.RLock()
+	defer p.gate.RUnlock()
+	if p.closed.Load() && !p.resumable(t) {
+		return p.reject(ErrPoolClosed)
+	}
+	if err := t.ctx.Err(); err != nil {
+		return p.reject(err)
+	}
+	p.enqueue(t)
+	return Receipt{Status: status}
+}
+
+// 任务入队,优先进提交方所在worker的本地队列
+func (p *stealPool) enqueue(t *task) {
+	p.inc()
+	t.at = time.Now()
+	t.scope = scopeOf(t.ctx).acquire()
+	if t.ctx.Done() != nil {
+		t.stop = context.AfterFunc(t.ctx, func() { p.cancel(t) })
+	}
+	if w, ok := t.ctx.Value(workerKey{}).(*stealWorker); !ok || w.pool != p || !w.local.push(t) {
+		p.global.push(t)
+	}
+	atomic.AddInt64(&p.size, 1)
+	if atomic.LoadInt32(&p.parked) > 0 {
+		p.mu.Lock()
+		p.notEmpty.Signal()
+		p.mu.Unlock()
+	}
+}
+
+// Block策略下等待队列腾出空位
+func (p *stealPool) waitSpace(ctx context.Context) {
+	p.mu.Lock()
+	atomic.AddInt32(&p.blocked, 1)
+	var stop func() bool
+	if ctx.Done() != nil {
+		stop = context.AfterFunc(ctx, func() {
+			p.mu.Lock()
+			p.notFull.Broadcast()
+			p.mu.Unlock()
+		})
+	}
+	for atomic.LoadInt64(&p.size) >= int64(p.qcap) && !p.closed.Load() && ctx.Err() == nil {
+		p.notFull.Wait()
+	}
+	atomic.AddInt32(&p.blocked, -1)
+	p.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+}
+
+// 认领一个出队的任务,已经被丢弃的返回false
+func (p *stealPool) claim(t *task) bool {
+	if !atomic.CompareAndSwapInt32(&t.state, waiting, claimed) {
+		return false
+	}
+	atomic.AddInt64(&p.size, -1)
+	if t.stop != nil {
+		t.stop()
+	}
+	if atomic.LoadInt32(&p.blocked) > 0 {
+		p.mu.Lock()
+		p.notFull.Signal()
+		p.mu.Unlock()
+	}
+	return true
+}
+
+// 取任务:先自己队尾,再全局队首,最后偷别人的队首,
+// 开启限流时令牌不够就把任务放回原处,返回还要等的时间
+func (p *stealPool) take(w *stealWorker) (*task, time.Duration) {
+	for {
+		t, from := w.local.popBack(), &w.local
+		if t == nil {
+			t, from = p.global.popFront(), &p.global
+		}
+		if t == nil {
+			t, from = p.steal(w)
+		}
+		if t == nil {
+			return nil, 0
+		}
+		if atomic.LoadInt32(&t.state) == waiting {
+			if d := p.tokens(t); d > 0 {
+				p.putBack(t, from, from == &w.local)
+				return nil, d
+			}
+			if p.claim(t) {
+				return t, 0
+			}
+			p.untoken(t)
+		}
+	}
+}
+
+// 没拿到令牌的任务放回取出来的地方,back表示从队尾取的,原来的worker退休了就放进全局队列
+func (p *stealPool) putBack(t *task, from *deque, back bool) {
+	if back && from.push(t) || !back && from.pushFront(t) {
+		return
+	}
+	p.global.pushFront(t)
+}
+
+// 从别的worker队首偷一个任务,同时返回偷的是哪个队列,w为nil表示谁的都可以偷
+func (p *stealPool) steal(w *stealWorker) (*task, *deque) {
+	ws := *p.ws.Load()
+	n := len(ws)
+	start := int(atomic.AddUint32(&p.seq, 1))
+	for i := 0; i < n; i++ {
+		v := ws[(start+i)%n]
+		if v == w {
+			continue
+		}
+		if t := v.local.popFront(); t != nil {
+			return t, &v.local
+		}
+	}
+	return nil, nil
+}
+
+// DropOldest策略下挤掉一个最老的任务
+func (p *stealPool) evict() *task {
+	t := p.global.popFront()
+	if t == nil {
+		t, _ = p.steal(nil)
+	}
+	if t != nil && p.claim(t) {
+		return t
+	}
+	return nil
+}
+
+// ctx取消后把还在排队的任务移出队列
+func (p *stealPool) cancel(t *task) {
+	if !atomic.CompareAndSwapInt32(&t.state, waiting, claimed) {
+		return
+	}
+	atomic.AddInt64(&p.size, -1)
+	//没找到说明正被别人取走,取走的一方认领失败后会直接跳过
+	if !p.global.remove(t) {
+		for _, w := range *p.ws.Load() {
+			if w.local.remove(t) {
+				break
+			}
+		}
+	}
+	//回调里不用再注销自己
+	p.dropped(t, t.ctx.Err())
+	p.dec()
+	if atomic.LoadInt32(&p.blocked) > 0 {
+		p.mu.Lock()
+		p.notFull.Signal()
+		p.mu.Unlock()
+	}
+}
+
+func (p *stealPool) discard(t *task, err error) {
+	p.drop(t, err)
+	p.dec()
+}
+
+// pending加一,从零变一时换一个新的idle
+func (p *stealPool) inc() {
+	if atomic.AddInt64(&p.pending, 1) != 1 {
+		return
+	}
+	p.mu.Lock()
+	if atomic.LoadInt64(&p.pending) > 0 && isClosed(p.idle) {
+		p.idle = make(chan struct{})
+	}
+	p.mu.Unlock()
+}
+
+// pending减一,归零时关闭idle
+func (p *stealPool) dec() {
+	if atomic.AddInt64(&p.pending, -1) != 0 {
+		return
+	}
+	p.mu.Lock()
+	if atomic.LoadInt64(&p.pending) == 0 && !isClosed(p.idle) {
+		close(p.idle)
+		//关闭后还留着等重试的worker,现在可以退出了
+		if p.closed.Load() {
+			p.notEmpty.Broadcast()
+		}
+	}
+	p.mu.Unlock()
+}
+
+func (p *stealPool) hold() {
+	p.inc()
+}
+
+func (p *stealPool) release() {
+	p.dec()
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// 启动一个新worker,调用方需持有mu
+func (p *stealPool) spawn() {
+	w := &stealWorker{pool: p}
+	old := *p.ws.Load()
+	ws := make([]*stealWorker, len(old), len(old)+1)
+	copy(ws, old)
+	ws = append(ws, w)
+	p.ws.Store(&ws)
+	p.live.Add(1)
+	go p.work(w)
+}
+
+// worker循环,没有任务可取时挂起,被裁撤或者关闭后队列空了就退出
+func (p *stealPool) work(w *stealWorker) {
+	p.enter(&w.worker)
+	abandon := func() { p.abandon(w) }
+	for !w.quit.Load() {
+		t, d := p.take(w)
+		if t == nil {
+			//等令牌时不占着任务,别的worker和后来的任务照常调度
+			if d > 0 {
+				time.Sleep(d)
+				continue
+			}
+			if !p.park(w) {
+				break
+			}
+			continue
+		}
+		if err := t.ctx.Err(); err != nil {
+			p.untoken(t)
+			p.discard(t, err)
+			continue
+		}
+
+		atomic.AddInt64(&p.busy, 1)
+		t.ctx = context.WithValue(t.ctx, workerKey{}, w)
+		if p.run(&w.worker, t, abandon) == errStraggler {
+			p.leave(&w.worker, false)
+			return
+		}
+		atomic.AddInt64(&p.busy, -1)
+		p.dec()
+	}
+	p.retire(w)
+	p.leave(&w.worker, true)
+}
+
+// 放弃执行掉队任务的worker,本地队列交出去,另起一个worker顶上
+func (p *stealPool) abandon(w *stealWorker) {
+	p.disown(&w.worker)
+	atomic.AddInt64(&p.busy, -1)
+	p.dec()
+	p.retire(w)
+	p.mu.Lock()
+	if !w.quit.Load() {
+		p.spawn()
+	}
+	p.mu.Unlock()
+	p.live.Done()
+}
+
+// 挂起直到有任务,需要退出时返回false
+func (p *stealPool) park(w *stealWorker) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	atomic.AddInt32(&p.parked, 1)
+	defer atomic.AddInt32(&p.parked, -1)
+	for atomic.LoadInt64(&p.size) <= 0 {
+		//关闭后还有等待重试之类的任务要再提交时不能退出,否则它们入队后没有worker执行
+		if w.quit.Load() || p.closed.Load() && (atomic.LoadInt64(&p.pending) == 0 || p.ctx.Err() != nil) {
+			return false
+		}
+		p.notEmpty.Wait()
+	}
+	return true
+}
+
+// worker退出,本地队列里剩下的任务交给全局队列
+func (p *stealPool) retire(w *stealWorker) {
+	p.mu.Lock()
+	old := *p.ws.Load()
+	ws := make([]*stealWorker, 0, len(old))
+	for _, v := range old {
+		if v != w {
+			ws = append(ws, v)
+		}
+	}
+	p.ws.Store(&ws)
+	p.mu.Unlock()
+	for _, t := range w.local.close() {
+		p.global.push(t)
+	}
+}
+
+func (p *stealPool) Wait() {
+	_ = p.WaitCtx(context.Background())
+}
+
+func (p *stealPool) WaitCtx(ctx context.Context) error {
+	p.mu.Lock()
+	idle := p.idle
+	p.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Resize 调整worker数量,多出来的worker执行完手上的任务后退出
+func (p *stealPool) Resize(n int) error {
+	if n <= 0 {
+		return ErrBadSize
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed.Load() {
+		return ErrPoolClosed
+	}
+	p.cap = n
+	ws := *p.ws.Load()
+	active := 0
+	for _, w := range ws {
+		if !w.quit.Load() {
+			active++
+		}
+	}
+	for ; active < n; active++ {
+		p.spawn()
+	}
+	for i := len(ws) - 1; i >= 0 && active > n; i-- {
+		if !ws[i].quit.Load() {
+			ws[i].quit.Store(true)
+			active--
+		}
+	}
+	p.notEmpty.Broadcast()
+	return nil
+}
+
+func (p *stealPool) Shutdown(ctx context.Context) error {
+	p.close()
+	if err := p.WaitCtx(ctx); err != nil {
+		return err
+	}
+	return p.drain(ctx)
+}
+
+func (p *stealPool) ShutdownNow() []CtxTaskFunc {
+	p.kill()
+	p.close()
+	//先Shutdown再ShutdownNow时close不会再唤醒,留着等重试的worker要在这里叫醒
+	p.mu.Lock()
+	p.notEmpty.Broadcast()
+	p.mu.Unlock()
+	var fs []CtxTaskFunc
+	grab := func(t *task) {
+		if p.claim(t) {
+			fs = append(fs, t.fn)
+			p.discard(t, ErrPoolClosed)
+		}
+	}
+	for t := p.global.popFront(); t != nil; t = p.global.popFront() {
+		grab(t)
+	}
+	for _, w := range *p.ws.Load() {
+		for t := w.local.popFront(); t != nil; t = w.local.popFront() {
+			grab(t)
+		}
+	}
+	return fs
+}
+
+// 标记关闭并唤醒所有等待的协程
+func (p *stealPool) close() {
+	p.gate.Lock()
+	p.mu.Lock()
+	if !p.closed.Load() {
+		p.closed.Store(true)
+		close(p.quit)
+		p.notFull.Broadcast()
+		p.notEmpty.Broadcast()
+	}
+	p.mu.Unlock()
+	p.gate.Unlock()
+}
+
+func (p *stealPool) Len() int {
+	return len(*p.ws.Load())
+}
+
+func (p *stealPool) Now() int {
+	return int(atomic.LoadInt64(&p.busy))
+}
+
+func (p *stealPool) Stats() Stats {
+	busy := p.Now()
+	s := Stats{
+		Queued: int(atomic.LoadInt64(&p.size)),
+		Busy:   busy,
+		Idle:   p.Len() - busy,
+		Timers: p.timers.len(),
+	}
+	if p.budget != nil {
+		s.BudgetUsed = p.budget.inUse()
+	}
+	p.stats.fill(&s)
+	return s
+}
@@ -0,0 +1,69 @@
+package Pool
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+// 父任务用自己的ctx提交的子任务进本地队列,父任务返回后也不会被丢弃
+func TestStealForkJoin(t *testing.T) {
+	p := New(4, WithWorkStealing(), WithQueue(1<<16, Block))
+	var n, local int64
+	for i := 0; i < 1000; i++ {
+		p.AssignCtx(context.Background(), func(ctx context.Context) {
+			parent := ctx.Value(workerKey{})
+			p.AssignCtx(ctx, func(ctx context.Context) {
+				if ctx.Value(workerKey{}) == parent {
+					atomic.AddInt64(&local, 1)
+				}
+				atomic.AddInt64(&n, 1)
+			})
+		})
+	}
+	p.Wait()
+	if n != 1000 {
+		t.Fatalf("children ran %d , want 1000", n)
+	}
+	if local == 0 {
+		t.Fatal("no child ran on its parent's worker")
+	}
+	if s := p.Stats(); s.Dropped != 0 {
+		t.Fatalf("dropped %d children", s.Dropped)
+	}
+}
+
+// 外部并发提交空任务
+func benchMicro(b *testing.B, p Pool) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			p.Assign(func() {})
+		}
+	})
+	p.Wait()
+	p.Shutdown(context.Background())
+}
+
+// 每个任务在里面再提交100个空任务
+func benchFork(b *testing.B, p Pool) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.AssignCtx(context.Background(), func(ctx context.Context) {
+			ctx = context.WithoutCancel(ctx)
+			for j := 0; j < 100; j++ {
+				p.AssignCtx(ctx, func(context.Context) {})
+			}
+		})
+	}
+	p.Wait()
+	p.Shutdown(context.Background())
+}
+
+func BenchmarkMicroDefault(b *testing.B) { benchMicro(b, New(8)) }
+func BenchmarkMicroSteal(b *testing.B)   { benchMicro(b, New(8, WithWorkStealing())) }
+
+func BenchmarkForkDefault(b *testing.B) { benchFork(b, New(8, WithQueue(1<<20, Block))) }
+func BenchmarkForkSteal(b *testing.B) {
+	benchFork(b, New(8, WithWorkStealing(), WithQueue(1<<20, Block)))
+}
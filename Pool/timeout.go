@@ -0,0 +1,90 @@
+package Pool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultGrace 任务超时后等它返回的默认时间,过了还没返回就算掉队
+const DefaultGrace = time.Second
+
+// ErrTimeout 任务执行超时时ctx的取消原因,ctx.Err()为context.Canceled,可以用context.Cause拿到
+var ErrTimeout = errors.New("task timed out")
+
+// errStraggler 任务掉队,worker已经被放弃,执行它的协程直接退出
+var errStraggler = errors.New("task straggled")
+
+// TimeoutHandler 任务超时的回调,elapsed为任务已执行的时间,
+// 超时取消ctx时回调一次straggler为false,超时后grace时间内还没返回再回调一次straggler为true
+type TimeoutHandler func(elapsed time.Duration, straggler bool)
+
+// TaskOption 单个任务的可选配置,用AssignWith提交时传入
+type TaskOption func(t *task)
+
+// Timeout 设置任务的执行超时,覆盖线程池的默认值,d小于0表示不限时
+func Timeout(d time.Duration) TaskOption {
+	return func(t *task) {
+		t.timeout = d
+	}
+}
+
+// 任务执行的超时看门狗
+type deadline struct {
+	state int32 //running,returned或者straggled,原子操作
+	timer *time.Timer
+}
+
+const (
+	running int32 = iota
+	returned
+	straggled
+)
+
+// 任务的执行超时,0表示不限时
+func (c *core) timeoutOf(t *task) time.Duration {
+	switch {
+	case t.timeout > 0:
+		return t.timeout
+	case t.timeout < 0:
+		return 0
+	}
+	return c.timeout
+}
+
+// 给执行中的任务加上超时,到期取消ctx,超时后grace时间内还没返回就调用abandon放弃它所在的worker
+func (c *core) deadline(ctx context.Context, d time.Duration, abandon func()) (context.Context, *deadline, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	start := time.Now()
+	dl := &deadline{}
+	dl.timer = time.AfterFunc(d, func() {
+		//已经返回的,或者ShutdownNow和提交方先取消的,不算超时
+		if atomic.LoadInt32(&dl.state) != running || ctx.Err() != nil {
+			return
+		}
+		//先记账和回调再取消,任务看到取消时超时已经记上了
+		atomic.AddInt64(&c.stats.timedOut, 1)
+		if c.onTimeout != nil {
+			c.onTimeout(time.Since(start), false)
+		}
+		cancel(ErrTimeout)
+		time.AfterFunc(c.grace, func() {
+			if !atomic.CompareAndSwapInt32(&dl.state, running, straggled) {
+				return
+			}
+			atomic.AddInt64(&c.stats.stragglers, 1)
+			if c.onTimeout != nil {
+				c.onTimeout(time.Since(start), true)
+			}
+			abandon()
+		})
+	})
+	return ctx, dl, func() { cancel(nil) }
+}
+
+// 任务返回,返回false表示任务已经被当作掉队放弃了
+func (dl *deadline) finish() bool {
+	dl.timer.Stop()
+	return atomic.CompareAndSwapInt32(&dl.state, running, returned)
+}
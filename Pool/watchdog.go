@@ -0,0 +1,165 @@
+package Pool
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StuckTask 执行超过阈值的任务
+type StuckTask struct {
+	ID        uint64        //看门狗分配的编号
+	Name      string        //Track时传入的名字,线程池里的任务为"pool"
+	Tenant    string        //线程池任务的租户
+	Priority  int           //线程池任务的优先级
+	Goroutine uint64        //执行任务的协程id
+	Started   time.Time     //开始执行的时间
+	Elapsed   time.Duration //发现时已经执行的时间
+	Stack     []byte        //执行任务的协程的调用栈,发现时任务已经结束则为nil
+}
+
+// StuckHandler 发现卡住的任务时的回调,每个任务只回调一次
+type StuckHandler func(s StuckTask)
+
+// Watchdog 看门狗,记录执行中任务的开始时间,执行超过阈值的任务连同它所在协程的调用栈交给回调,
+// 通过WithWatchdog交给线程池后,线程池的每个任务都会被记录,别的代码(比如委托函数)可以自己调用Track
+type Watchdog interface {
+	Track(name string) (done func()) //在执行任务的协程上调用,任务结束时调用done
+	Check() []StuckTask              //立即检查一次,返回这次新发现的卡住的任务
+	Stop()                           //停止后台检查
+	back() *watchdog
+}
+
+// 看门狗实体
+type watchdog struct {
+	threshold time.Duration
+	onStuck   StuckHandler
+
+	mu      sync.Mutex
+	seq     uint64
+	running map[uint64]*watched
+
+	quit chan struct{}
+	once sync.Once
+}
+
+// 一个执行中的任务
+type watched struct {
+	info    StuckTask
+	flagged bool //是否已经报告过
+}
+
+// NewWatchdog 执行超过threshold的任务交给onStuck,interval大于0时在后台每隔interval检查一次,
+// 否则只在调用Check时检查
+func NewWatchdog(threshold, interval time.Duration, onStuck StuckHandler) Watchdog {
+	w := &watchdog{threshold: threshold, onStuck: onStuck, running: make(map[uint64]*watched), quit: make(chan struct{})}
+	if interval > 0 {
+		go w.loop(interval)
+	}
+	return w
+}
+
+func (w *watchdog) loop(interval time.Duration) {
+	tk := time.NewTicker(interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-w.quit:
+			return
+		case <-tk.C:
+			w.Check()
+		}
+	}
+}
+
+func (w *watchdog) Track(name string) func() {
+	return w.track(StuckTask{Name: name})
+}
+
+// 记录当前协程上开始执行的任务,返回注销函数
+func (w *watchdog) track(info StuckTask) func() {
+	info.Goroutine = goid()
+	info.Started = time.Now()
+	w.mu.Lock()
+	w.seq++
+	info.ID = w.seq
+	w.running[info.ID] = &watched{info: info}
+	w.mu.Unlock()
+	return func() {
+		w.mu.Lock()
+		delete(w.running, info.ID)
+		w.mu.Unlock()
+	}
+}
+
+func (w *watchdog) Check() []StuckTask {
+	now := time.Now()
+	var stuck []StuckTask
+	w.mu.Lock()
+	for _, wt := range w.running {
+		if !wt.flagged && now.Sub(wt.info.Started) >= w.threshold {
+			wt.flagged = true
+			stuck = append(stuck, wt.info)
+		}
+	}
+	w.mu.Unlock()
+	if len(stuck) == 0 {
+		return nil
+	}
+
+	//一次抓全部协程的栈,再按协程id挑出来
+	dump := stacks()
+	for i := range stuck {
+		s := &stuck[i]
+		s.Elapsed = now.Sub(s.Started)
+		s.Stack = stackOf(dump, s.Goroutine)
+		if w.onStuck != nil {
+			w.onStuck(*s)
+		}
+	}
+	return stuck
+}
+
+func (w *watchdog) Stop() {
+	w.once.Do(func() { close(w.quit) })
+}
+
+func (w *watchdog) back() *watchdog {
+	return w
+}
+
+// 当前协程的id,从runtime.Stack的第一行"goroutine N [...]"里取
+func goid() uint64 {
+	var buf [64]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// 所有协程的调用栈,缓冲不够时加倍重试
+func stacks() []byte {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// 从全部调用栈里挑出协程id的那一段,找不到返回nil
+func stackOf(dump []byte, id uint64) []byte {
+	head := []byte("goroutine " + strconv.FormatUint(id, 10) + " [")
+	for _, g := range bytes.Split(dump, []byte("\n\n")) {
+		if bytes.HasPrefix(g, head) {
+			return append([]byte(nil), g...)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package Pool
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTick 时间轮默认的刻度
+const DefaultTick = time.Millisecond
+
+// 时间轮的层级:第0层256格,往上每层64格,共5层,刻度1ms时最远约49天,更远的到期前会反复降级
+const (
+	wheelBits0  = 8
+	wheelBits   = 6
+	wheelLevels = 5
+)
+
+// Timer 定时任务的句柄
+type Timer interface {
+	Stop() bool //取消还没到期的任务,已经到期交给线程池或者已经取消的返回false
+}
+
+// timer 时间轮里的一个定时任务,挂在某一格的双向链表上
+type timer struct {
+	w          *wheel
+	at         int64 //到期的刻度
+	fn         CtxTaskFunc
+	prev, next *timer
+	slot       *timer //所在格子的哨兵,nil表示不在时间轮里
+}
+
+// wheel 分层时间轮,到期的任务提交给线程池,只在有定时任务时才走表
+type wheel struct {
+	mu      sync.Mutex
+	tick    time.Duration
+	start   time.Time
+	now     int64 //下一个要处理的刻度
+	count   int   //还没到期的任务数
+	levels  [wheelLevels][]timer
+	wake    chan struct{}
+	running bool
+}
+
+// 到期时间换算成刻度,向上取整,不会提前执行,很远的到期时间封顶,加tick-1时不会溢出
+func (w *wheel) tickOf(at time.Time) int64 {
+	d := at.Sub(w.start)
+	if d > math.MaxInt64-w.tick {
+		return int64(math.MaxInt64 / w.tick)
+	}
+	return int64((d + w.tick - 1) / w.tick)
+}
+
+// 第一次使用时初始化,调用方需持有锁
+func (w *wheel) init() {
+	w.start = time.Now()
+	w.wake = make(chan struct{}, 1)
+	for l := range w.levels {
+		n := 1 << wheelBits
+		if l == 0 {
+			n = 1 << wheelBits0
+		}
+		w.levels[l] = make([]timer, n)
+		for i := range w.levels[l] {
+			s := &w.levels[l][i]
+			s.prev, s.next = s, s
+		}
+	}
+}
+
+// 按到期刻度挂到对应层的格子上,调用方需持有锁
+func (w *wheel) add(e *timer) {
+	at := e.at
+	delta := at - w.now
+	if delta < 0 {
+		at, delta = w.now, 0
+	}
+	level, shift := 0, 0
+	span := int64(1) << wheelBits0
+	for level < wheelLevels-1 && delta >= span {
+		level++
+		shift = wheelBits0 + (level-1)*wheelBits
+		span <<= wheelBits
+	}
+	if delta >= span {
+		//超出最上层,先挂在最远的格子上,降级时再重新算
+		at = w.now + span - 1
+	}
+	slots := w.levels[level]
+	s := &slots[(at>>shift)&int64(len(slots)-1)]
+	e.slot = s
+	e.prev, e.next = s.prev, s
+	s.prev.next = e
+	s.prev = e
+}
+
+// 从格子上摘下来,调用方需持有锁
+func (w *wheel) unlink(e *timer) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.prev, e.next, e.slot = nil, nil, nil
+}
+
+// 摘下一整格,调用方需持有锁
+func (w *wheel) take(s *timer) []*timer {
+	var es []*timer
+	for e := s.next; e != s; {
+		next := e.next
+		w.unlink(e)
+		es = append(es, e)
+		e = next
+	}
+	return es
+}
+
+// 走到at对应的刻度,返回到期的任务,调用方需持有锁
+func (w *wheel) advance(at time.Time) []*timer {
+	var due []*timer
+	target := int64(at.Sub(w.start) / w.tick)
+	for ; w.now <= target; w.now++ {
+		if w.count == 0 {
+			//没有任务就不用一格一格走了
+			w.now = target + 1
+			break
+		}
+		//下面各层都转完一圈时,把上一层当前的格子降级下来
+		for l := 1; l < wheelLevels; l++ {
+			shift := wheelBits0 + (l-1)*wheelBits
+			if w.now&(1<<shift-1) != 0 {
+				break
+			}
+			slots := w.levels[l]
+			for _, e := range w.take(&slots[(w.now>>shift)&int64(len(slots)-1)]) {
+				w.add(e)
+			}
+		}
+		for _, e := range w.take(&w.levels[0][w.now&(1<<wheelBits0-1)]) {
+			if e.at > w.now {
+				w.add(e)
+				continue
+			}
+			w.count--
+			due = append(due, e)
+		}
+	}
+	return due
+}
+
+// 走表的协程,没有任务时停表等唤醒,线程池关闭时丢弃剩下的任务
+func (w *wheel) run(c *core, submit func(t *task) Receipt) {
+	tk := time.NewTicker(w.tick)
+	defer tk.Stop()
+	for {
+		select {
+		case now := <-tk.C:
+			w.mu.Lock()
+			due := w.advance(now)
+			idle := w.count == 0
+			w.mu.Unlock()
+			//队列满时按线程池的策略处理,Block策略下走表会等队列腾出位置
+			for _, e := range due {
+				submit(newTask(context.Background(), e.fn))
+			}
+			if !idle {
+				continue
+			}
+			tk.Stop()
+			select {
+			case <-w.wake:
+				tk.Reset(w.tick)
+			case <-c.quit:
+				return
+			}
+		case <-c.quit:
+			w.mu.Lock()
+			n := w.count
+			for l := range w.levels {
+				for i := range w.levels[l] {
+					w.take(&w.levels[l][i])
+				}
+			}
+			w.count = 0
+			w.mu.Unlock()
+			atomic.AddInt64(&c.stats.dropped, int64(n))
+			return
+		}
+	}
+}
+
+// 还没到期的任务数
+func (w *wheel) len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+func (e *timer) Stop() bool {
+	w := e.w
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if e.slot == nil {
+		return false
+	}
+	w.unlink(e)
+	w.count--
+	return true
+}
+
+// 把fn挂到时间轮上,at到期后提交给线程池,线程池关闭后直接拒绝
+func (c *core) schedule(submit func(t *task) Receipt, at time.Time, fn CtxTaskFunc) Timer {
+	w := &c.timers
+	e := &timer{w: w, fn: fn}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	select {
+	case <-c.quit:
+		atomic.AddInt64(&c.stats.submitted, 1)
+		c.reject(ErrPoolClosed)
+		return e
+	default:
+	}
+	if !w.running {
+		w.tick = c.tick
+		w.init()
+		w.running = true
+		go w.run(c, submit)
+	}
+	if w.count == 0 {
+		//停表期间没有走,从当前时刻接着算
+		w.now = int64(time.Since(w.start) / w.tick)
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+	e.at = w.tickOf(at)
+	w.add(e)
+	w.count++
+	return e
+}
+
+// AssignAfter d之后把f提交给线程池,等待期间不占队列和worker,也不计入Wait,Shutdown时还没到期的直接丢弃
+func (p *pool) AssignAfter(d time.Duration, f TaskFunc) Timer {
+	return p.schedule(p.submit, time.Now().Add(d), wrap([]TaskFunc{f})[0])
+}
+
+// AssignAt 在at时刻把f提交给线程池,其余同AssignAfter
+func (p *pool) AssignAt(at time.Time, f TaskFunc) Timer {
+	return p.schedule(p.submit, at, wrap([]TaskFunc{f})[0])
+}
+
+func (p *stealPool) AssignAfter(d time.Duration, f TaskFunc) Timer {
+	return p.schedule(p.submit, time.Now().Add(d), wrap([]TaskFunc{f})[0])
+}
+
+func (p *stealPool) AssignAt(at time.Time, f TaskFunc) Timer {
+	return p.schedule(p.submit, at, wrap([]TaskFunc{f})[0])
+}
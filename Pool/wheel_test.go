@@ -0,0 +1,64 @@
+package Pool
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 先挂上1M个远期的定时任务,再测新增,取消和到期
+func BenchmarkAssignAfter1M(b *testing.B) {
+	const pending = 1000000
+	p := New(8, WithQueue(1<<20, Block))
+	defer p.ShutdownNow()
+	for i := 0; i < pending; i++ {
+		p.AssignAfter(time.Hour+time.Duration(i)*time.Millisecond, func() {})
+	}
+
+	b.Run("insert", func(b *testing.B) {
+		b.ReportAllocs()
+		ts := make([]Timer, 0, b.N)
+		for i := 0; i < b.N; i++ {
+			ts = append(ts, p.AssignAfter(time.Hour, func() {}))
+		}
+		b.StopTimer()
+		for _, t := range ts {
+			t.Stop()
+		}
+	})
+
+	b.Run("stop", func(b *testing.B) {
+		b.StopTimer()
+		ts := make([]Timer, 0, b.N)
+		for i := 0; i < b.N; i++ {
+			ts = append(ts, p.AssignAfter(time.Hour, func() {}))
+		}
+		b.StartTimer()
+		for _, t := range ts {
+			if !t.Stop() {
+				b.Fatal("timer already fired")
+			}
+		}
+	})
+
+	b.Run("expire", func(b *testing.B) {
+		var n int64
+		done := make(chan struct{})
+		for i := 0; i < b.N; i++ {
+			p.AssignAfter(time.Millisecond, func() {
+				if atomic.AddInt64(&n, 1) == int64(b.N) {
+					close(done)
+				}
+			})
+		}
+		select {
+		case <-done:
+		case <-time.After(time.Minute):
+			b.Fatalf("%d of %d timers fired", atomic.LoadInt64(&n), b.N)
+		}
+	})
+
+	if s := p.Stats(); s.Timers != pending {
+		b.Fatalf("pending timers %d , want %d", s.Timers, pending)
+	}
+}